@@ -0,0 +1,72 @@
+package main
+
+import "strings"
+
+// LineBlame records who last touched a line. Lines from the starter code
+// have no author.
+type LineBlame struct {
+	PlayerID   string `json:"playerId,omitempty"`
+	PlayerName string `json:"playerName,omitempty"`
+	Timestamp  int64  `json:"timestamp,omitempty"`
+}
+
+type BlameLine struct {
+	Line int    `json:"line"` // 1-based
+	Text string `json:"text"`
+	LineBlame
+}
+
+// newBlame attributes every line of code to nobody
+func newBlame(code string) []LineBlame {
+	return make([]LineBlame, strings.Count(code, "\n")+1)
+}
+
+// applyBlame shifts the line map through the hunks of one edit and
+// attributes the added lines to author
+func applyBlame(blame []LineBlame, hunks []DiffHunk, author LineBlame) []LineBlame {
+	// Walk backwards so earlier hunks' line numbers stay valid
+	for i := len(hunks) - 1; i >= 0; i-- {
+		h := hunks[i]
+		start := h.OldStart
+		if h.OldLines > 0 {
+			start--
+		}
+		if start > len(blame) || start+h.OldLines > len(blame) {
+			// Out of sync; should never happen, but don't panic mid-game
+			continue
+		}
+
+		added := make([]LineBlame, h.NewLines)
+		for j := range added {
+			added[j] = author
+		}
+
+		updated := make([]LineBlame, 0, len(blame)-h.OldLines+h.NewLines)
+		updated = append(updated, blame[:start]...)
+		updated = append(updated, added...)
+		updated = append(updated, blame[start+h.OldLines:]...)
+		blame = updated
+	}
+	return blame
+}
+
+// blameLocked pairs the line map with the current code.
+// Caller must hold r.mutex.
+func (r *Room) blameLocked() []BlameLine {
+	lines := strings.Split(r.currentCode, "\n")
+	result := make([]BlameLine, 0, len(lines))
+	for i, text := range lines {
+		entry := BlameLine{Line: i + 1, Text: text}
+		if i < len(r.blame) {
+			entry.LineBlame = r.blame[i]
+		}
+		result = append(result, entry)
+	}
+	return result
+}
+
+func (r *Room) Blame() []BlameLine {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.blameLocked()
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestApplyBlame(t *testing.T) {
+	alice := LineBlame{PlayerID: "alice", PlayerName: "Alice", Timestamp: 1}
+	bob := LineBlame{PlayerID: "bob", PlayerName: "Bob", Timestamp: 2}
+
+	tests := []struct {
+		name     string
+		old, new string
+		before   []LineBlame
+		want     []LineBlame
+	}{
+		{
+			name:   "insert",
+			old:    "a\nc",
+			new:    "a\nb\nc",
+			before: []LineBlame{alice, alice},
+			want:   []LineBlame{alice, bob, alice},
+		},
+		{
+			name:   "insert at the top",
+			old:    "b\nc",
+			new:    "a\nb\nc",
+			before: []LineBlame{{}, alice},
+			want:   []LineBlame{bob, {}, alice},
+		},
+		{
+			name:   "delete",
+			old:    "a\nb\nc",
+			new:    "a\nc",
+			before: []LineBlame{{}, alice, {}},
+			want:   []LineBlame{{}, {}},
+		},
+		{
+			name:   "replace",
+			old:    "a\nb\nc",
+			new:    "a\nB\nB2\nc",
+			before: []LineBlame{alice, alice, alice},
+			want:   []LineBlame{alice, bob, bob, alice},
+		},
+		{
+			name:   "several hunks",
+			old:    "a\nb\nc\nd\ne",
+			new:    "a\nc\nd\nD\ne",
+			before: []LineBlame{{}, {}, alice, {}, alice},
+			want:   []LineBlame{{}, alice, {}, bob, alice},
+		},
+		{
+			name:   "out of sync hunks are skipped",
+			old:    "a\nb\nc",
+			new:    "a\nB\nc",
+			before: []LineBlame{alice},
+			want:   []LineBlame{alice},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := applyBlame(tt.before, DiffLines(tt.old, tt.new), bob)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewBlame(t *testing.T) {
+	if got := len(newBlame("a\nb\n")); got != 3 {
+		t.Errorf("got %d lines, want 3", got)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Room visibility. Public and password rooms are listed by the room
+// browser; private rooms can only be joined with their code.
+const (
+	VisibilityPublic   = "public"
+	VisibilityPrivate  = "private"
+	VisibilityPassword = "password"
+)
+
+const maxRoomPasswordLength = 64
+
+// RoomSummary is a room as shown in the room browser
+type RoomSummary struct {
+	Code              string    `json:"code"`
+	HostName          string    `json:"hostName"`
+	Players           int       `json:"players"`
+	Capacity          int       `json:"capacity"`
+	Spectators        int       `json:"spectators"`
+	State             GameState `json:"state"`
+	Visibility        string    `json:"visibility"`
+	PasswordProtected bool      `json:"passwordProtected"`
+	Language          string    `json:"language,omitempty"`
+}
+
+func (r *Room) Summary() RoomSummary {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	hostName := ""
+	for _, p := range r.allPlayersLocked() {
+		if p.ID == r.hostID {
+			hostName = p.Name
+			break
+		}
+	}
+
+	return RoomSummary{
+		Code:              r.code,
+		HostName:          hostName,
+		Players:           len(r.players) + len(r.detached),
+		Capacity:          r.settings.MaxPlayers,
+		Spectators:        len(r.spectators),
+		State:             r.gameState,
+		Visibility:        r.settings.Visibility,
+		PasswordProtected: r.settings.Visibility == VisibilityPassword,
+		Language:          r.settings.Language,
+	}
+}
+
+// ListedRooms returns every public or password-protected room, joinable
+// lobbies first
+func (h *Hub) ListedRooms() []RoomSummary {
+	rooms := h.Rooms()
+	summaries := make([]RoomSummary, 0, len(rooms))
+	for _, room := range rooms {
+		summary := room.Summary()
+		if summary.Visibility == VisibilityPrivate {
+			continue
+		}
+		summaries = append(summaries, summary)
+	}
+
+	sort.Slice(summaries, func(i, j int) bool {
+		a, b := summaries[i], summaries[j]
+		if (a.State == StateLobby) != (b.State == StateLobby) {
+			return a.State == StateLobby
+		}
+		return a.Code < b.Code
+	})
+	return summaries
+}
+
+// ServeRooms handles GET /api/rooms and GET /api/rooms/{code}
+func ServeRooms(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	code := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/rooms"), "/")
+	if code == "" {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"rooms": hub.ListedRooms(),
+		})
+		return
+	}
+
+	// Anyone holding a code may look the room up, private or not
+	room := hub.GetRoom(code)
+	if room == nil {
+		http.Error(w, "room not found", http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(room.Summary())
+}
+
+// setPasswordLocked stores a salted hash of the room password.
+// Caller must hold r.mutex.
+func (r *Room) setPasswordLocked(password string) {
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	r.passwordSalt = salt
+	r.passwordHash = hashRoomPassword(salt, password)
+}
+
+// CheckPassword reports whether password lets a client into the room
+func (r *Room) CheckPassword(password string) bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	if r.settings.Visibility != VisibilityPassword {
+		return true
+	}
+	return subtle.ConstantTimeCompare(hashRoomPassword(r.passwordSalt, password), r.passwordHash) == 1
+}
+
+func hashRoomPassword(salt []byte, password string) []byte {
+	sum := sha256.Sum256(append(append([]byte{}, salt...), password...))
+	return sum[:]
+}
@@ -2,39 +2,53 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
-const (
-	writeWait      = 10 * time.Second
-	pongWait       = 60 * time.Second
-	pingPeriod     = (pongWait * 9) / 10
-	maxMessageSize = 65536
-)
-
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		return true // Allow all origins for development
-	},
-}
-
 type Client struct {
-	id        string
-	hub       *Hub
-	conn      *websocket.Conn
-	send      chan []byte
-	room      *Room
-	closeOnce sync.Once
+	id          string
+	hub         *Hub
+	config      *Config
+	conn        *websocket.Conn
+	send        chan []byte
+	done        chan struct{} // closed when the connection shuts down
+	drained     chan struct{} // poked when writePump takes a message, for deliver
+	sendMutex   sync.Mutex
+	closed      bool
+	room        *Room
+	spectating  *Room // read-only attachment, never set together with room
+	replay      *replaySession
+	replayMutex sync.Mutex
+	closeOnce   sync.Once
+	// protocolVersion is fixed when the connection is upgraded
+	protocolVersion int
+	// handling is the type of the message being handled, for error
+	// replies; only readPump touches it
+	handling string
+	flood    *floodControl
+	// profile is the identity the client connected with, nil for an
+	// anonymous connection
+	profile *Profile
 }
 
+// ChatChannelImpostor is readable only by impostors, living or dead, and
+// ChatChannelGhost only by ghosts; the default (empty) channel goes to
+// the whole room
+const (
+	ChatChannelImpostor = "impostor"
+	ChatChannelGhost    = "ghost"
+)
+
 type Message struct {
 	Type string          `json:"type"`
 	Data json.RawMessage `json:"data"`
@@ -42,21 +56,58 @@ type Message struct {
 
 func (c *Client) cleanup() {
 	c.closeOnce.Do(func() {
+		// Unblock any deliver call before taking the send lock
+		close(c.done)
+		c.hub.metrics.clientsConnected.Add(-1)
+		c.sendMutex.Lock()
+		c.closed = true
 		close(c.send)
+		c.sendMutex.Unlock()
 		c.conn.Close()
 	})
 }
 
+// deliver queues a message for a goroutine that outlives room membership,
+// like a replay. It waits for space and returns false once the client
+// has been closed. The wait doesn't hold sendMutex, so rooms sending to
+// the same client with trySend are never held up by it.
+func (c *Client) deliver(data []byte) bool {
+	for !c.trySend(data) {
+		select {
+		case <-c.drained:
+		case <-c.done:
+			return false
+		}
+	}
+	return true
+}
+
+// trySend queues a message without waiting, dropping it if the queue is
+// full or the client has been closed
+func (c *Client) trySend(data []byte) bool {
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+	if c.closed {
+		return false
+	}
+	select {
+	case c.send <- data:
+		return true
+	default:
+		return false
+	}
+}
+
 func (c *Client) readPump() {
 	defer func() {
 		c.hub.unregister <- c
 		c.cleanup()
 	}()
 
-	c.conn.SetReadLimit(maxMessageSize)
-	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetReadLimit(int64(c.config.MaxMessageSize))
+	c.conn.SetReadDeadline(time.Now().Add(c.config.PongWait.D()))
 	c.conn.SetPongHandler(func(string) error {
-		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+		c.conn.SetReadDeadline(time.Now().Add(c.config.PongWait.D()))
 		return nil
 	})
 
@@ -70,78 +121,184 @@ func (c *Client) readPump() {
 		}
 
 		c.handleMessage(message)
+		if c.flood.disconnected {
+			break
+		}
 	}
 }
 
 func (c *Client) handleMessage(message []byte) {
 	var msg Message
 	if err := json.Unmarshal(message, &msg); err != nil {
-		log.Printf("Error parsing message: %v", err)
+		c.handling = ""
+		if c.allowMessage("invalid") {
+			c.sendError(ErrCodeBadRequest, "Message is not valid JSON!")
+		}
+		return
+	}
+	c.hub.metrics.MessageIn(msg.Type)
+	c.handling = msg.Type
+
+	if _, ok := clientMessages[msg.Type]; !ok {
+		if c.allowMessage("unknown") {
+			c.sendError(ErrCodeUnknownType, fmt.Sprintf("Unknown message type %q!", msg.Type))
+		}
+		return
+	}
+	if !c.allowMessage(msg.Type) {
 		return
 	}
 
 	switch msg.Type {
 	case "create-room":
-		var data struct {
-			PlayerName string `json:"playerName"`
+		var data CreateRoomRequest
+		if c.decode(msg.Data, &data) {
+			c.handleCreateRoom(c.playerName(data.PlayerName))
 		}
-		json.Unmarshal(msg.Data, &data)
-		c.handleCreateRoom(data.PlayerName)
 
 	case "join-room":
-		var data struct {
-			RoomCode   string `json:"roomCode"`
-			PlayerName string `json:"playerName"`
+		var data JoinRoomRequest
+		if c.decode(msg.Data, &data) {
+			c.handleJoinRoom(data.RoomCode, c.playerName(data.PlayerName), data.Password)
+		}
+
+	case "resume-session":
+		var data ResumeSessionRequest
+		if c.decode(msg.Data, &data) {
+			c.handleResumeSession(data.Token)
 		}
-		json.Unmarshal(msg.Data, &data)
-		c.handleJoinRoom(data.RoomCode, data.PlayerName)
+
+	case "replay":
+		var data ReplayRequest
+		if c.decode(msg.Data, &data) {
+			c.handleReplay(data.GameID, data.Speed)
+		}
+
+	case "replay-control":
+		var data ReplayControlRequest
+		if c.decode(msg.Data, &data) {
+			c.handleReplayControl(data.Seek, data.Speed)
+		}
+
+	case "replay-stop":
+		c.ControlReplay(replayControl{seek: -1, stop: true})
+
+	case "spectate-room":
+		var data SpectateRoomRequest
+		if c.decode(msg.Data, &data) {
+			c.handleSpectateRoom(data.RoomCode, data.Password)
+		}
+
+	case "queue-join":
+		var data QueueJoinRequest
+		if c.decode(msg.Data, &data) {
+			c.handleQueueJoin(c.playerName(data.PlayerName), data.Language, data.Difficulty)
+		}
+
+	case "queue-leave":
+		c.handleQueueLeave()
+
+	case "queue-ready":
+		c.handleQueueReady()
 
 	case "start-game":
 		c.handleStartGame()
 
+	case "update-settings":
+		c.handleUpdateSettings(msg.Data)
+
+	case "kick-player":
+		var data KickPlayerRequest
+		if c.decode(msg.Data, &data) {
+			c.handleKickPlayer(data.PlayerID)
+		}
+
+	case "transfer-host":
+		var data TransferHostRequest
+		if c.decode(msg.Data, &data) {
+			c.handleTransferHost(data.PlayerID)
+		}
+
 	case "code-update":
-		var data struct {
-			Code string `json:"code"`
+		var data CodeUpdateRequest
+		if c.decode(msg.Data, &data) {
+			c.handleCodeUpdate(data.Code)
+		}
+
+	case "code-op":
+		var data CodeOpRequest
+		if c.decode(msg.Data, &data) {
+			c.handleCodeOp(data.Revision, data.Ops)
 		}
-		json.Unmarshal(msg.Data, &data)
-		c.handleCodeUpdate(data.Code)
+
+	case "code-sync":
+		c.handleCodeSync()
 
 	case "call-meeting":
 		c.handleCallMeeting()
 
 	case "cast-vote":
-		var data struct {
-			TargetID string `json:"targetId"`
+		var data CastVoteRequest
+		if c.decode(msg.Data, &data) {
+			c.handleCastVote(data.TargetID)
 		}
-		json.Unmarshal(msg.Data, &data)
-		c.handleCastVote(data.TargetID)
+
+	case "blame":
+		c.handleBlame()
 
 	case "chat-message":
-		var data struct {
-			Message string `json:"message"`
+		var data ChatMessageRequest
+		if c.decode(msg.Data, &data) {
+			c.handleChatMessage(data.Message, data.Channel)
 		}
-		json.Unmarshal(msg.Data, &data)
-		c.handleChatMessage(data.Message)
 
 	case "submit-task":
-		var data struct {
-			Passed bool `json:"passed"`
+		c.handleSubmitTask()
+
+	case "ghost-task":
+		var data GhostTaskRequest
+		if c.decode(msg.Data, &data) {
+			c.handleGhostTask(data.Code)
 		}
-		json.Unmarshal(msg.Data, &data)
-		c.handleSubmitTask(data.Passed)
+
+	case "play-again":
+		c.handlePlayAgain()
+	}
+}
+
+// decode unmarshals a message's data, replying with bad_request if it
+// doesn't fit the message type
+func (c *Client) decode(raw json.RawMessage, v interface{}) bool {
+	if err := decodeData(raw, v); err != nil {
+		c.sendError(ErrCodeBadRequest, "Invalid message data: "+err.Error())
+		return false
 	}
+	return true
 }
 
 func (c *Client) handleCreateRoom(playerName string) {
+	if c.hub.IsDraining() {
+		c.sendError(ErrCodeServerDraining, "Server is restarting, no new rooms can be created!")
+		return
+	}
+
+	if c.spectating != nil {
+		c.spectating.RemoveSpectator(c)
+	}
+	c.hub.matchmaker.Remove(c)
+
 	roomCode := GenerateRoomCode()
 	room := c.hub.CreateRoom(roomCode)
 	player := room.AddPlayer(c, playerName)
 
-	response := map[string]interface{}{
-		"type":     "room-created",
-		"roomCode": roomCode,
-		"player":   player,
-		"players":  room.GetPlayersPublic(),
+	response := RoomJoinedMessage{
+		Type:        "room-created",
+		RoomCode:    roomCode,
+		Player:      player,
+		Players:     room.GetPlayersPublic(),
+		Settings:    room.Settings(),
+		TaskPool:    room.TaskPool(),
+		ResumeToken: c.hub.IssueResumeToken(roomCode, player.ID),
 	}
 	data, _ := json.Marshal(response)
 	c.send <- data
@@ -149,32 +306,73 @@ func (c *Client) handleCreateRoom(playerName string) {
 	log.Printf("🏠 [LGTM] Room created: %s by %s", roomCode, playerName)
 }
 
-func (c *Client) handleJoinRoom(roomCode, playerName string) {
+func (c *Client) handleJoinRoom(roomCode, playerName, password string) {
 	room := c.hub.GetRoom(roomCode)
 
 	if room == nil {
-		c.sendError("Room not found!")
+		c.sendError(ErrCodeNotFound, "Room not found!")
+		return
+	}
+
+	if !room.CheckPassword(password) {
+		if password == "" {
+			c.sendError(ErrCodePasswordRequired, "Password required!")
+		} else {
+			c.sendError(ErrCodeWrongPassword, "Wrong password!")
+		}
+		return
+	}
+
+	room.mutex.RLock()
+	full := len(room.players) >= room.settings.MaxPlayers
+	gameState := room.gameState
+	kicked := room.kicked[c.id]
+	seated := false
+	for _, p := range room.allPlayersLocked() {
+		if p.ID == c.id {
+			seated = true
+			break
+		}
+	}
+	room.mutex.RUnlock()
+
+	if kicked {
+		c.sendError(ErrCodeNotAllowed, "You were removed from this room!")
 		return
 	}
 
-	if len(room.players) >= 4 {
-		c.sendError("Room is full!")
+	// Same identity on another connection
+	if seated {
+		c.sendError(ErrCodeInvalidState, "You're already in this room!")
 		return
 	}
 
-	if room.gameState != StateLobby {
-		c.sendError("Game already in progress!")
+	if full {
+		c.sendError(ErrCodeRoomFull, "Room is full!")
 		return
 	}
 
+	if gameState != StateLobby {
+		c.sendError(ErrCodeInvalidState, "Game already in progress!")
+		return
+	}
+
+	if c.spectating != nil {
+		c.spectating.RemoveSpectator(c)
+	}
+	c.hub.matchmaker.Remove(c)
+
 	player := room.AddPlayer(c, playerName)
 
 	// Send to joining player
-	response := map[string]interface{}{
-		"type":     "room-joined",
-		"roomCode": roomCode,
-		"player":   player,
-		"players":  room.GetPlayersPublic(),
+	response := RoomJoinedMessage{
+		Type:        "room-joined",
+		RoomCode:    roomCode,
+		Player:      player,
+		Players:     room.GetPlayersPublic(),
+		Settings:    room.Settings(),
+		TaskPool:    room.TaskPool(),
+		ResumeToken: c.hub.IssueResumeToken(roomCode, player.ID),
 	}
 	data, _ := json.Marshal(response)
 	c.send <- data
@@ -185,20 +383,156 @@ func (c *Client) handleJoinRoom(roomCode, playerName string) {
 	log.Printf("👤 [LGTM] %s joined room: %s", playerName, roomCode)
 }
 
+func (c *Client) handleResumeSession(token string) {
+	if c.room != nil {
+		c.sendError(ErrCodeInvalidState, "Already in a room!")
+		return
+	}
+
+	roomCode, playerID, err := c.hub.ParseResumeToken(token)
+	if err != nil {
+		c.sendError(ErrCodeSessionExpired, "Session could not be resumed!")
+		return
+	}
+	if c.profile != nil && playerID != c.id {
+		c.sendError(ErrCodeNotAllowed, "That seat belongs to another player!")
+		return
+	}
+
+	room := c.hub.GetRoom(roomCode)
+	if room == nil {
+		c.sendError(ErrCodeSessionExpired, "Room no longer exists!")
+		return
+	}
+
+	player := room.ResumePlayer(c, playerID)
+	if player == nil {
+		c.sendError(ErrCodeSessionExpired, "Session expired!")
+		return
+	}
+
+	snapshot := room.SessionSnapshot(player)
+	snapshot.ResumeToken = token
+	room.SendToClient(c, snapshot)
+	room.BroadcastPlayerList()
+
+	log.Printf("🔁 [LGTM] %s resumed session in room: %s", player.Name, roomCode)
+}
+
+func (c *Client) handleReplay(gameID string, speed int) {
+	if c.room != nil {
+		c.sendError(ErrCodeInvalidState, "Leave the room before watching a replay!")
+		return
+	}
+
+	if speed == 0 {
+		speed = 1
+	}
+	if !replaySpeeds[speed] {
+		c.sendError(ErrCodeBadRequest, "Replay speed must be 1, 4 or 16!")
+		return
+	}
+
+	gameLog, err := c.hub.recordings.Load(gameID)
+	if err != nil {
+		c.sendError(ErrCodeNotFound, "Recording not found!")
+		return
+	}
+
+	c.StartReplay(gameLog, speed)
+	log.Printf("📼 [LGTM] Replaying game %s at %dx", gameID, speed)
+}
+
+func (c *Client) handleReplayControl(seek *int64, speed int) {
+	if speed != 0 && !replaySpeeds[speed] {
+		c.sendError(ErrCodeBadRequest, "Replay speed must be 1, 4 or 16!")
+		return
+	}
+
+	ctrl := replayControl{seek: -1, speed: speed}
+	if seek != nil && *seek >= 0 {
+		ctrl.seek = *seek
+	}
+	c.ControlReplay(ctrl)
+}
+
 func (c *Client) handleStartGame() {
 	if c.room == nil {
 		return
 	}
 
-	if len(c.room.players) < 4 {
-		c.sendError("Need 4 players to start!")
+	c.room.mutex.RLock()
+	isHost := c.room.hostID == c.id
+	playerCount := len(c.room.players)
+	minPlayers := c.room.settings.MinPlayers
+	gameState := c.room.gameState
+	c.room.mutex.RUnlock()
+
+	if !isHost {
+		c.sendError(ErrCodeNotAllowed, "Only the host can start the game!")
+		return
+	}
+
+	if gameState != StateLobby {
+		return
+	}
+
+	if playerCount < minPlayers {
+		c.sendError(ErrCodeInvalidState, fmt.Sprintf("Need %d players to start!", minPlayers))
 		return
 	}
 
-	c.room.StartGame()
+	if c.hub.IsDraining() {
+		c.sendError(ErrCodeServerDraining, "Server is restarting, no new games can start!")
+		return
+	}
+
+	// A quick-play game starts by itself once everyone accepts
+	if c.hub.matchmaker.Checking(c.room) {
+		c.sendError(ErrCodeInvalidState, "Wait for everyone to accept!")
+		return
+	}
+
+	err := c.room.StartGame()
+	if errors.Is(err, errNotInLobby) {
+		return
+	}
+	if err != nil {
+		c.sendError(ErrCodeInvalidSettings, "Can't start game: "+err.Error())
+		return
+	}
 	log.Printf("🎮 [LGTM] Game started in room: %s", c.room.code)
 }
 
+func (c *Client) handleUpdateSettings(raw json.RawMessage) {
+	if c.room == nil {
+		return
+	}
+
+	c.room.mutex.RLock()
+	isHost := c.room.hostID == c.id
+	c.room.mutex.RUnlock()
+
+	if !isHost {
+		c.sendError(ErrCodeNotAllowed, "Only the host can change settings!")
+		return
+	}
+
+	// Fields left out of the message keep their current value
+	data := UpdateSettingsRequest{RoomSettings: c.room.Settings()}
+	if !c.decode(raw, &data) {
+		return
+	}
+	settings := data.RoomSettings
+
+	if err := c.room.UpdateSettings(settings, data.Password); err != nil {
+		c.sendError(ErrCodeInvalidSettings, "Invalid settings: "+err.Error())
+		return
+	}
+
+	log.Printf("⚙️ [LGTM] Settings updated in room %s: %+v", c.room.code, settings)
+}
+
 func (c *Client) handleCodeUpdate(code string) {
 	if c.room == nil {
 		return
@@ -213,15 +547,24 @@ func (c *Client) handleCodeUpdate(code string) {
 		return
 	}
 
-	c.room.UpdateCode(c, code)
+	// Check before diffing; the diff is quadratic in the worst case
+	if len(code) > c.config.MaxCodeSize {
+		c.rejectTooLarge("Code", "bytes", len(code), c.config.MaxCodeSize)
+		return
+	}
+	switch err := c.room.UpdateCode(c, code); {
+	case errors.Is(err, errGhostEdit):
+		c.sendError(ErrCodeInvalidState, "Ghosts can't edit the code!")
+	case errors.Is(err, errCodeTooLarge):
+		c.rejectTooLarge("Code", "bytes", len(code), c.config.MaxCodeSize)
+	}
 }
 
-func (c *Client) handleCallMeeting() {
+func (c *Client) handleCodeOp(revision int, edits []Edit) {
 	if c.room == nil {
 		return
 	}
 
-	// Check game state safely
 	c.room.mutex.RLock()
 	gameState := c.room.gameState
 	c.room.mutex.RUnlock()
@@ -230,7 +573,59 @@ func (c *Client) handleCallMeeting() {
 		return
 	}
 
-	c.room.CallMeeting(c)
+	err := c.room.ApplyOperation(c, revision, edits)
+	if errors.Is(err, errGhostEdit) {
+		c.sendError(ErrCodeInvalidState, "Ghosts can't edit the code!")
+		return
+	}
+	if errors.Is(err, errStaleRevision) {
+		// Too far behind to transform; start over from a snapshot
+		c.room.SendToClient(c, c.room.CodeSnapshot())
+		return
+	}
+	if errors.Is(err, errCodeTooLarge) {
+		c.rejectTooLarge("Code", "bytes", -1, c.config.MaxCodeSize)
+		return
+	}
+	if err != nil {
+		c.sendError(ErrCodeInvalidEdit, "Invalid edit!")
+		log.Printf("[LGTM] Rejected code-op in room %s: %v", c.room.code, err)
+	}
+}
+
+func (c *Client) handleCodeSync() {
+	if c.room == nil {
+		return
+	}
+	c.room.SendToClient(c, c.room.CodeSnapshot())
+}
+
+func (c *Client) handleCallMeeting() {
+	if c.room == nil {
+		return
+	}
+
+	err := c.room.CallMeeting(c)
+	var cooldown *MeetingCooldownError
+	switch {
+	case err == nil:
+	case errors.As(err, &cooldown):
+		c.sendErrorMessage(ErrorMessage{
+			Type:         "error",
+			Code:         ErrCodeMeetingCooldown,
+			Message:      fmt.Sprintf("Next meeting available in %ds!", cooldown.Seconds()),
+			MessageType:  c.handling,
+			RetryAfterMs: cooldown.Remaining.Milliseconds(),
+		})
+	case errors.Is(err, errMeetingEjected):
+		c.sendError(ErrCodeNotAllowed, "Ejected players can't call meetings!")
+	case errors.Is(err, errNoMeetingsLeft):
+		c.sendError(ErrCodeNotAllowed, "You have no meetings left!")
+	case errors.Is(err, errMeetingNotNow):
+		c.sendError(ErrCodeNotAllowed, "Meetings can only be called during the game!")
+	case errors.Is(err, errMeetingNotPlayer):
+		c.sendError(ErrCodeNotAllowed, "Only players can call meetings!")
+	}
 }
 
 func (c *Client) handleCastVote(targetID string) {
@@ -250,73 +645,113 @@ func (c *Client) handleCastVote(targetID string) {
 	c.room.CastVote(c, targetID)
 }
 
-func (c *Client) handleChatMessage(message string) {
+func (c *Client) handleBlame() {
 	if c.room == nil {
 		return
 	}
 
 	c.room.mutex.RLock()
-	player := c.room.players[c]
-	isAlive := player != nil && player.IsAlive
+	gameState := c.room.gameState
 	c.room.mutex.RUnlock()
 
-	if !isAlive {
+	if gameState != StateVoting {
+		c.sendError(ErrCodeInvalidState, "Blame is only available during meetings!")
 		return
 	}
 
-	chatMsg := map[string]interface{}{
-		"type":        "chat-message",
-		"playerId":    player.ID,
-		"playerName":  player.Name,
-		"playerColor": player.Color,
-		"message":     message,
-		"timestamp":   time.Now().UnixMilli(),
-	}
-	data, _ := json.Marshal(chatMsg)
-	c.room.broadcast <- data
+	c.room.SendToClient(c, BlameMessage{
+		Type:  "blame",
+		Lines: c.room.Blame(),
+	})
 }
 
-func (c *Client) handleSubmitTask(passed bool) {
+func (c *Client) handleChatMessage(message, channel string) {
 	if c.room == nil {
 		return
 	}
 
-	// Check game state safely
 	c.room.mutex.RLock()
+	player := c.room.players[c]
+	isAlive := player != nil && player.IsAlive
 	gameState := c.room.gameState
 	c.room.mutex.RUnlock()
 
-	if gameState != StatePlaying {
+	if player == nil || strings.TrimSpace(message) == "" {
+		return
+	}
+	inGame := gameState == StatePlaying || gameState == StateVoting
+
+	if length := utf8.RuneCountInString(message); length > c.config.MaxChatLength {
+		c.rejectTooLarge("Chat message", "characters", length, c.config.MaxChatLength)
 		return
 	}
 
-	if passed {
-		// Engineers win! All tests passed on client side
-		c.room.EndGame("engineers", "Task completed successfully! All tests passed! 🎉")
-		log.Printf("✅ [LGTM] Task submitted successfully in room: %s", c.room.code)
-	} else {
-		// Send failure message
-		response := map[string]interface{}{
-			"type":    "task-failed",
-			"message": "Tests failed! Fix the code and try again.",
+	switch channel {
+	case "":
+		if !isAlive {
+			return
 		}
-		data, _ := json.Marshal(response)
-		c.send <- data
-		log.Printf("❌ [LGTM] Task submission failed in room: %s", c.room.code)
+	case ChatChannelImpostor:
+		if !isAlive || player.Role != "impostor" || !inGame {
+			return
+		}
+	case ChatChannelGhost:
+		if isAlive || !inGame {
+			c.sendError(ErrCodeNotAllowed, "Only ghosts can use the ghost channel!")
+			return
+		}
+	default:
+		c.sendError(ErrCodeBadRequest, "Unknown chat channel!")
+		return
 	}
+
+	c.room.AddChatMessage(ChatRecord{
+		PlayerID:    player.ID,
+		PlayerName:  player.Name,
+		PlayerColor: player.Color,
+		Message:     message,
+		Channel:     channel,
+		Timestamp:   time.Now().UnixMilli(),
+	})
 }
 
-func (c *Client) sendError(message string) {
-	response := map[string]interface{}{
-		"type":    "error",
-		"message": message,
+func (c *Client) handleSubmitTask() {
+	if c.room == nil {
+		return
+	}
+
+	// Snapshot the code under the lock; the client's own verdict is ignored
+	c.room.mutex.Lock()
+	if c.room.gameState != StatePlaying || c.room.currentTask == nil || c.room.submitting {
+		c.room.mutex.Unlock()
+		return
 	}
+	c.room.submitting = true
+	task := c.room.currentTask
+	code := c.room.currentCode
+	c.room.mutex.Unlock()
+
+	go c.room.VerifySubmission(c, task, code)
+}
+
+// sendError replies to the message being handled with a machine-readable
+// code and a message for the player
+func (c *Client) sendError(code, message string) {
+	c.sendErrorMessage(ErrorMessage{
+		Type:        "error",
+		Code:        code,
+		Message:     message,
+		MessageType: c.handling,
+	})
+}
+
+func (c *Client) sendErrorMessage(response ErrorMessage) {
 	data, _ := json.Marshal(response)
 	c.send <- data
 }
 
 func (c *Client) writePump() {
-	ticker := time.NewTicker(pingPeriod)
+	ticker := time.NewTicker(c.config.PingPeriod())
 	defer func() {
 		ticker.Stop()
 		c.cleanup()
@@ -325,12 +760,16 @@ func (c *Client) writePump() {
 	for {
 		select {
 		case message, ok := <-c.send:
-			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			c.conn.SetWriteDeadline(time.Now().Add(c.config.WriteWait.D()))
 			if !ok {
 				// Channel closed, send close message
 				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
 				return
 			}
+			select {
+			case c.drained <- struct{}{}:
+			default:
+			}
 
 			w, err := c.conn.NextWriter(websocket.TextMessage)
 			if err != nil {
@@ -341,9 +780,10 @@ func (c *Client) writePump() {
 			if err := w.Close(); err != nil {
 				return
 			}
+			c.hub.metrics.MessageOut(message, len(c.send))
 
 		case <-ticker.C:
-			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			c.conn.SetWriteDeadline(time.Now().Add(c.config.WriteWait.D()))
 			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
 			}
@@ -352,20 +792,55 @@ func (c *Client) writePump() {
 }
 
 func ServeWs(hub *Hub, w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+	version, ok := negotiateProtocol(websocket.Subprotocols(r))
+	if !ok {
+		http.Error(w, fmt.Sprintf("unsupported protocol, this server speaks %s", strings.Join(Subprotocols(), ", ")), http.StatusBadRequest)
+		return
+	}
+
+	// Checked before upgrading so a bad token gets a plain 401
+	profile, err := hub.identity.Authenticate(r)
+	if err != nil {
+		http.Error(w, "invalid or expired identity token", http.StatusUnauthorized)
+		return
+	}
+	id := uuid.New().String()
+	if profile != nil {
+		id = profile.ID
+	}
+
+	conn, err := hub.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println(err)
 		return
 	}
 
 	client := &Client{
-		id:        uuid.New().String(),
-		hub:       hub,
-		conn:      conn,
-		send:      make(chan []byte, 256),
-		closeOnce: sync.Once{},
+		id:              id,
+		hub:             hub,
+		config:          hub.config,
+		conn:            conn,
+		send:            make(chan []byte, hub.config.SendQueueSize),
+		done:            make(chan struct{}),
+		drained:         make(chan struct{}, 1),
+		closeOnce:       sync.Once{},
+		protocolVersion: version,
+		flood:           newFloodControl(),
+		profile:         profile,
 	}
 
+	hub.metrics.clientsConnected.Add(1)
+	welcome := WelcomeMessage{
+		Type:            "welcome",
+		ProtocolVersion: version,
+		ClientID:        client.id,
+	}
+	if profile != nil {
+		public := profile.Public()
+		welcome.Profile = &public
+	}
+	welcomeData, _ := json.Marshal(welcome)
+	client.send <- welcomeData
 	client.hub.register <- client
 
 	go client.writePump()
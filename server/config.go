@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Config holds every server setting. It is loaded once at startup from
+// defaults, then a JSON file, then LGTM_* environment variables, then
+// flags, each layer overriding the one before.
+type Config struct {
+	Addr          string   `json:"addr"`
+	StaticDir     string   `json:"staticDir"`
+	Tasks         string   `json:"tasks"`
+	TasksReload   Duration `json:"tasksReload"`
+	RecordingsDir string   `json:"recordingsDir"`
+	DrainTimeout  Duration `json:"drainTimeout"`
+
+	// WebSocket connections
+	ReadBufferSize  int      `json:"readBufferSize"`
+	WriteBufferSize int      `json:"writeBufferSize"`
+	SendQueueSize   int      `json:"sendQueueSize"`
+	MaxMessageSize  int      `json:"maxMessageSize"`
+	WriteWait       Duration `json:"writeWait"`
+	PongWait        Duration `json:"pongWait"`
+
+	// Flood protection. Message rates are limited per client and type in
+	// ratelimit.go; these bound what one message may add.
+	MaxChatLength int `json:"maxChatLength"` // characters
+	MaxCodeSize   int `json:"maxCodeSize"`   // bytes
+
+	// Game timers. GameTime, VotingTime and the meeting settings are the
+	// defaults for new rooms, in seconds; hosts can still change them
+	// within the room limits.
+	GameTime          int      `json:"gameTime"`
+	VotingTime        int      `json:"votingTime"`
+	MeetingCooldown   int      `json:"meetingCooldown"`
+	MeetingsPerPlayer int      `json:"meetingsPerPlayer"`
+	ResultsDelay      Duration `json:"resultsDelay"`
+	ResumeGracePeriod Duration `json:"resumeGracePeriod"`
+
+	// Quick play
+	QueueReadyTimeout Duration `json:"queueReadyTimeout"`
+
+	// Player identities
+	ProfilesFile     string   `json:"profilesFile"`
+	IdentityKeyFile  string   `json:"identityKeyFile"` // signs identity tokens, created if missing
+	IdentityTokenTTL Duration `json:"identityTokenTtl"`
+	ClientIPHeader   string   `json:"clientIpHeader"` // set by a trusted reverse proxy
+	RatingsFile      string   `json:"ratingsFile"`    // ratings and match history
+
+	// Submission runner
+	RunnerTimeout      Duration `json:"runnerTimeout"`
+	RunnerBuildTimeout Duration `json:"runnerBuildTimeout"`
+	RunnerTestTimeout  Duration `json:"runnerTestTimeout"`
+	RunnerCPUSeconds   int      `json:"runnerCpuSeconds"`
+	RunnerMemoryMB     int      `json:"runnerMemoryMb"`
+	RunnerMaxProcs     int      `json:"runnerMaxProcs"`   // shared by all running submissions
+	RunnerSandbox      bool     `json:"runnerSandbox"`    // needs root; only turn off for development
+	RunnerNamespaces   bool     `json:"runnerNamespaces"` // needs CAP_SYS_ADMIN
+	RunnerUID          int      `json:"runnerUid"`
+	RunnerGID          int      `json:"runnerGid"`
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		Addr:          ":8081",
+		StaticDir:     "../client/dist",
+		Tasks:         "tasks.json",
+		TasksReload:   Duration(2 * time.Second),
+		RecordingsDir: "data/recordings",
+		DrainTimeout:  Duration(5 * time.Minute),
+
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		SendQueueSize:   256,
+		MaxMessageSize:  65536,
+		WriteWait:       Duration(10 * time.Second),
+		PongWait:        Duration(60 * time.Second),
+
+		MaxChatLength: 500,
+		MaxCodeSize:   32768,
+
+		GameTime:          180,
+		VotingTime:        60,
+		MeetingCooldown:   20,
+		MeetingsPerPlayer: 1,
+		ResultsDelay:      Duration(3 * time.Second),
+		ResumeGracePeriod: Duration(60 * time.Second),
+
+		QueueReadyTimeout: Duration(15 * time.Second),
+
+		ProfilesFile:     "data/profiles.jsonl",
+		IdentityKeyFile:  "data/identity.key",
+		IdentityTokenTTL: Duration(30 * 24 * time.Hour),
+		RatingsFile:      "data/ratings.json",
+
+		RunnerTimeout:      Duration(10 * time.Second),
+		RunnerBuildTimeout: Duration(30 * time.Second),
+		RunnerTestTimeout:  Duration(1 * time.Second),
+		RunnerCPUSeconds:   5,
+		RunnerMemoryMB:     128,
+		RunnerMaxProcs:     256,
+		RunnerSandbox:      true,
+		RunnerNamespaces:   true,
+		RunnerUID:          65534, // nobody
+		RunnerGID:          65534,
+	}
+}
+
+// PingPeriod is how often the server pings; it must be under PongWait
+func (c *Config) PingPeriod() time.Duration {
+	return c.PongWait.D() * 9 / 10
+}
+
+// bind registers every setting on fs, with the current values as defaults.
+// Flag names double as environment variable names: -pong-wait is
+// LGTM_PONG_WAIT.
+func (c *Config) bind(fs *flag.FlagSet) {
+	fs.StringVar(&c.Addr, "addr", c.Addr, "address to listen on")
+	fs.StringVar(&c.StaticDir, "static-dir", c.StaticDir, "directory of the built client")
+	fs.StringVar(&c.Tasks, "tasks", c.Tasks, "path to the task catalog")
+	fs.Var(&c.TasksReload, "tasks-reload", "how often to check the task catalog for changes (0 disables)")
+	fs.StringVar(&c.RecordingsDir, "recordings-dir", c.RecordingsDir, "directory game recordings are written to")
+	fs.Var(&c.DrainTimeout, "drain-timeout", "how long running games may continue after SIGTERM")
+
+	fs.IntVar(&c.ReadBufferSize, "read-buffer-size", c.ReadBufferSize, "websocket read buffer size in bytes")
+	fs.IntVar(&c.WriteBufferSize, "write-buffer-size", c.WriteBufferSize, "websocket write buffer size in bytes")
+	fs.IntVar(&c.SendQueueSize, "send-queue-size", c.SendQueueSize, "messages queued per client before it is dropped")
+	fs.IntVar(&c.MaxMessageSize, "max-message-size", c.MaxMessageSize, "largest message accepted from a client, in bytes")
+	fs.Var(&c.WriteWait, "write-wait", "time allowed to write a message to a client")
+	fs.Var(&c.PongWait, "pong-wait", "time allowed to read the next pong from a client")
+
+	fs.IntVar(&c.MaxChatLength, "max-chat-length", c.MaxChatLength, "longest chat message, in characters")
+	fs.IntVar(&c.MaxCodeSize, "max-code-size", c.MaxCodeSize, "largest shared code buffer, in bytes")
+
+	fs.IntVar(&c.GameTime, "game-time", c.GameTime, "default game length for new rooms, in seconds")
+	fs.IntVar(&c.VotingTime, "voting-time", c.VotingTime, "default voting length for new rooms, in seconds")
+	fs.IntVar(&c.MeetingCooldown, "meeting-cooldown", c.MeetingCooldown, "default wait before and between meetings for new rooms, in seconds")
+	fs.IntVar(&c.MeetingsPerPlayer, "meetings-per-player", c.MeetingsPerPlayer, "default meetings each player may call per game")
+	fs.Var(&c.ResultsDelay, "results-delay", "pause after vote results before the game goes on")
+	fs.Var(&c.ResumeGracePeriod, "resume-grace-period", "how long a disconnected player's seat is held")
+
+	fs.Var(&c.QueueReadyTimeout, "queue-ready-timeout", "how long matched quick-play players have to accept")
+
+	fs.StringVar(&c.ProfilesFile, "profiles-file", c.ProfilesFile, "file player profiles are kept in")
+	fs.StringVar(&c.IdentityKeyFile, "identity-key-file", c.IdentityKeyFile, "key identity tokens are signed with, created if missing")
+	fs.Var(&c.IdentityTokenTTL, "identity-token-ttl", "how long an identity token stays valid")
+	fs.StringVar(&c.ClientIPHeader, "client-ip-header", c.ClientIPHeader, "header a trusted reverse proxy puts the client's address in, like X-Forwarded-For; identity rate limits use the connection's address if unset")
+	fs.StringVar(&c.RatingsFile, "ratings-file", c.RatingsFile, "file player ratings and match history are kept in")
+
+	fs.Var(&c.RunnerTimeout, "runner-timeout", "wall-clock limit for running a submission")
+	fs.Var(&c.RunnerBuildTimeout, "runner-build-timeout", "wall-clock limit for compiling a submission")
+	fs.Var(&c.RunnerTestTimeout, "runner-test-timeout", "time limit per test case")
+	fs.IntVar(&c.RunnerCPUSeconds, "runner-cpu-seconds", c.RunnerCPUSeconds, "CPU time limit for a submission")
+	fs.IntVar(&c.RunnerMemoryMB, "runner-memory-mb", c.RunnerMemoryMB, "memory limit for a submission, in MB")
+	fs.IntVar(&c.RunnerMaxProcs, "runner-max-procs", c.RunnerMaxProcs, "processes and threads all sandboxed submissions may have at once")
+	fs.BoolVar(&c.RunnerSandbox, "runner-sandbox", c.RunnerSandbox, "run submissions as the runner user (needs root)")
+	fs.BoolVar(&c.RunnerNamespaces, "runner-namespaces", c.RunnerNamespaces, "also run sandboxed submissions in their own network, PID, IPC and UTS namespaces (needs CAP_SYS_ADMIN)")
+	fs.IntVar(&c.RunnerUID, "runner-uid", c.RunnerUID, "user ID submissions run as")
+	fs.IntVar(&c.RunnerGID, "runner-gid", c.RunnerGID, "group ID submissions run as")
+}
+
+// Validate reports every invalid setting at once
+func (c *Config) Validate() error {
+	problems := make([]string, 0)
+	report := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if c.Addr == "" {
+		report("addr must not be empty")
+	}
+	if c.Tasks == "" {
+		report("tasks must not be empty")
+	}
+	if c.RecordingsDir == "" {
+		report("recordingsDir must not be empty")
+	}
+	if c.ProfilesFile == "" {
+		report("profilesFile must not be empty")
+	}
+	if c.IdentityKeyFile == "" {
+		report("identityKeyFile must not be empty")
+	}
+	if c.RatingsFile == "" {
+		report("ratingsFile must not be empty")
+	}
+	if c.TasksReload < 0 {
+		report("tasksReload can't be negative")
+	}
+	if c.DrainTimeout < 0 {
+		report("drainTimeout can't be negative")
+	}
+
+	for _, size := range []struct {
+		name  string
+		value int
+	}{
+		{"readBufferSize", c.ReadBufferSize},
+		{"writeBufferSize", c.WriteBufferSize},
+		{"sendQueueSize", c.SendQueueSize},
+		{"maxMessageSize", c.MaxMessageSize},
+		{"maxChatLength", c.MaxChatLength},
+		{"maxCodeSize", c.MaxCodeSize},
+		{"runnerCpuSeconds", c.RunnerCPUSeconds},
+		{"runnerMaxProcs", c.RunnerMaxProcs},
+		{"runnerUid", c.RunnerUID},
+		{"runnerGid", c.RunnerGID},
+	} {
+		if size.value <= 0 {
+			report("%s must be positive, got %d", size.name, size.value)
+		}
+	}
+	if c.RunnerMemoryMB < 32 {
+		report("runnerMemoryMb must be at least 32, got %d", c.RunnerMemoryMB)
+	}
+
+	for _, d := range []struct {
+		name  string
+		value Duration
+	}{
+		{"writeWait", c.WriteWait},
+		{"pongWait", c.PongWait},
+		{"resultsDelay", c.ResultsDelay},
+		{"resumeGracePeriod", c.ResumeGracePeriod},
+		{"queueReadyTimeout", c.QueueReadyTimeout},
+		{"identityTokenTtl", c.IdentityTokenTTL},
+		{"runnerTimeout", c.RunnerTimeout},
+		{"runnerBuildTimeout", c.RunnerBuildTimeout},
+		{"runnerTestTimeout", c.RunnerTestTimeout},
+	} {
+		if d.value <= 0 {
+			report("%s must be positive, got %v", d.name, d.value)
+		}
+	}
+	if c.PongWait > 0 && c.PongWait.D() < time.Second {
+		report("pongWait must be at least 1s")
+	}
+
+	if c.GameTime < minGameTime || c.GameTime > maxGameTime {
+		report("gameTime must be between %d and %d seconds, got %d", minGameTime, maxGameTime, c.GameTime)
+	}
+	if c.VotingTime < minVotingTime || c.VotingTime > maxVotingTime {
+		report("votingTime must be between %d and %d seconds, got %d", minVotingTime, maxVotingTime, c.VotingTime)
+	}
+	if c.MeetingCooldown < 0 || c.MeetingCooldown > maxMeetingCooldown {
+		report("meetingCooldown must be between 0 and %d seconds, got %d", maxMeetingCooldown, c.MeetingCooldown)
+	}
+	if c.MeetingsPerPlayer < minMeetingsPerPlayer || c.MeetingsPerPlayer > maxMeetingsPerPlayer {
+		report("meetingsPerPlayer must be between %d and %d, got %d", minMeetingsPerPlayer, maxMeetingsPerPlayer, c.MeetingsPerPlayer)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid config:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// LoadConfig builds the config from defaults, the file named by -config
+// or LGTM_CONFIG, LGTM_* environment variables and the command line
+func LoadConfig(args []string) (*Config, error) {
+	// Parse the command line first only to learn -config and which flags
+	// were given; they are applied last
+	flags := flag.NewFlagSet("lgtm", flag.ContinueOnError)
+	configPath := flags.String("config", os.Getenv("LGTM_CONFIG"), "path to a JSON config file (env LGTM_CONFIG)")
+	DefaultConfig().bind(flags)
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := DefaultConfig()
+	if *configPath != "" {
+		if err := cfg.loadFile(*configPath); err != nil {
+			return nil, fmt.Errorf("config file %s: %w", *configPath, err)
+		}
+	}
+
+	target := flag.NewFlagSet("lgtm", flag.ContinueOnError)
+	target.SetOutput(io.Discard)
+	cfg.bind(target)
+
+	var envErr error
+	target.VisitAll(func(f *flag.Flag) {
+		name := "LGTM_" + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		if value, ok := os.LookupEnv(name); ok && envErr == nil {
+			if err := target.Set(f.Name, value); err != nil {
+				envErr = fmt.Errorf("%s: invalid value %q: %w", name, value, err)
+			}
+		}
+	})
+	if envErr != nil {
+		return nil, envErr
+	}
+
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name != "config" {
+			target.Set(f.Name, f.Value.String())
+		}
+	})
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(c); err != nil {
+		return err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return errors.New("unexpected data after config object")
+	}
+	return nil
+}
+
+// Duration is a time.Duration written as "30s" in JSON and flags
+type Duration time.Duration
+
+func (d Duration) D() time.Duration {
+	return time.Duration(d)
+}
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d *Duration) Set(value string) error {
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("duration must be a string like \"30s\", got %s", data)
+	}
+	if err := d.Set(value); err != nil {
+		return fmt.Errorf("invalid duration %q", value)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"gameTime": 200, "pongWait": "30s"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		env      map[string]string
+		args     []string
+		gameTime int
+		pongWait time.Duration
+	}{
+		{"defaults", nil, nil, 180, 60 * time.Second},
+		{"file", map[string]string{"LGTM_CONFIG": path}, nil, 200, 30 * time.Second},
+		{"file from flag", nil, []string{"-config", path}, 200, 30 * time.Second},
+		{"env over file", map[string]string{"LGTM_CONFIG": path, "LGTM_GAME_TIME": "300"}, nil, 300, 30 * time.Second},
+		{"flag over env", map[string]string{"LGTM_GAME_TIME": "300"}, []string{"-game-time", "400"}, 400, 60 * time.Second},
+		{"flag over env and file", map[string]string{"LGTM_CONFIG": path, "LGTM_GAME_TIME": "300", "LGTM_PONG_WAIT": "20s"}, []string{"-game-time", "400"}, 400, 20 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("LGTM_CONFIG", "")
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			cfg, err := LoadConfig(tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.GameTime != tt.gameTime || cfg.PongWait.D() != tt.pongWait {
+				t.Errorf("got gameTime %d, pongWait %v; want %d, %v", cfg.GameTime, cfg.PongWait, tt.gameTime, tt.pongWait)
+			}
+		})
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	unknown := write("unknown.json", `{"gameTimeout": 200}`)
+	trailing := write("trailing.json", `{"gameTime": 200} {}`)
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		args []string
+	}{
+		{"missing file", nil, []string{"-config", filepath.Join(dir, "missing.json")}},
+		{"unknown field", nil, []string{"-config", unknown}},
+		{"data after the object", nil, []string{"-config", trailing}},
+		{"bad env value", map[string]string{"LGTM_GAME_TIME": "soon"}, nil},
+		{"bad flag value", nil, []string{"-pong-wait", "soon"}},
+		{"out of range", nil, []string{"-game-time", "10"}},
+		{"unknown flag", nil, []string{"-game-timeout", "200"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("LGTM_CONFIG", "")
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			if _, err := LoadConfig(tt.args); err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+}
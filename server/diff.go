@@ -0,0 +1,104 @@
+package main
+
+import "strings"
+
+// maxDiffCells caps the LCS table, which is built under the room lock
+// on every edit. Typing only changes a line or two once the common
+// prefix and suffix are trimmed; larger changes, like a big paste over
+// existing code, are reported as one hunk replacing the changed region.
+const maxDiffCells = 1 << 14
+
+// DiffHunk is one contiguous change. Line numbers are 1-based; a start
+// with zero lines is the position the change sits after.
+type DiffHunk struct {
+	OldStart int      `json:"oldStart"`
+	OldLines int      `json:"oldLines"`
+	NewStart int      `json:"newStart"`
+	NewLines int      `json:"newLines"`
+	Removed  []string `json:"removed"`
+	Added    []string `json:"added"`
+}
+
+// DiffLines computes a line diff between two versions of the code
+func DiffLines(oldText, newText string) []DiffHunk {
+	a := strings.Split(oldText, "\n")
+	b := strings.Split(newText, "\n")
+
+	// Trim the common prefix and suffix so the table only covers the edit
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	a = a[prefix : len(a)-suffix]
+	b = b[prefix : len(b)-suffix]
+
+	if len(a) == 0 && len(b) == 0 {
+		return []DiffHunk{}
+	}
+	if (len(a)+1)*(len(b)+1) > maxDiffCells {
+		return []DiffHunk{newHunk(prefix, prefix, a, b)}
+	}
+
+	// lcs[i][j] is the LCS length of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	hunks := make([]DiffHunk, 0)
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		if i < len(a) && j < len(b) && a[i] == b[j] {
+			i++
+			j++
+			continue
+		}
+
+		// Collect a run of removals and additions up to the next match
+		si, sj := i, j
+		for i < len(a) || j < len(b) {
+			if i < len(a) && j < len(b) && a[i] == b[j] {
+				break
+			}
+			if j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]) {
+				i++
+			} else {
+				j++
+			}
+		}
+		hunks = append(hunks, newHunk(prefix+si, prefix+sj, a[si:i], b[sj:j]))
+	}
+	return hunks
+}
+
+// newHunk builds a hunk from 0-based offsets into the full line lists
+func newHunk(oldOffset, newOffset int, removed, added []string) DiffHunk {
+	h := DiffHunk{
+		OldStart: oldOffset + 1,
+		OldLines: len(removed),
+		NewStart: newOffset + 1,
+		NewLines: len(added),
+		Removed:  append([]string{}, removed...),
+		Added:    append([]string{}, added...),
+	}
+	if h.OldLines == 0 {
+		h.OldStart = oldOffset
+	}
+	if h.NewLines == 0 {
+		h.NewStart = newOffset
+	}
+	return h
+}
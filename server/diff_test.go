@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new string
+		want     []DiffHunk
+	}{
+		{
+			name: "no change",
+			old:  "a\nb",
+			new:  "a\nb",
+			want: []DiffHunk{},
+		},
+		{
+			name: "insert in the middle",
+			old:  "a\nc",
+			new:  "a\nb\nc",
+			want: []DiffHunk{
+				{OldStart: 1, OldLines: 0, NewStart: 2, NewLines: 1, Removed: []string{}, Added: []string{"b"}},
+			},
+		},
+		{
+			name: "insert at the top",
+			old:  "b",
+			new:  "a\nb",
+			want: []DiffHunk{
+				{OldStart: 0, OldLines: 0, NewStart: 1, NewLines: 1, Removed: []string{}, Added: []string{"a"}},
+			},
+		},
+		{
+			name: "delete",
+			old:  "a\nb\nc",
+			new:  "a\nc",
+			want: []DiffHunk{
+				{OldStart: 2, OldLines: 1, NewStart: 1, NewLines: 0, Removed: []string{"b"}, Added: []string{}},
+			},
+		},
+		{
+			name: "replace",
+			old:  "a\nb\nc",
+			new:  "a\nB\nc",
+			want: []DiffHunk{
+				{OldStart: 2, OldLines: 1, NewStart: 2, NewLines: 1, Removed: []string{"b"}, Added: []string{"B"}},
+			},
+		},
+		{
+			name: "two separate changes",
+			old:  "a\nb\nc\nd\ne",
+			new:  "a\nc\nd\nD\ne",
+			want: []DiffHunk{
+				{OldStart: 2, OldLines: 1, NewStart: 1, NewLines: 0, Removed: []string{"b"}, Added: []string{}},
+				{OldStart: 4, OldLines: 0, NewStart: 4, NewLines: 1, Removed: []string{}, Added: []string{"D"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := DiffLines(tt.old, tt.new)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DiffLines(%q, %q)\n got %+v\nwant %+v", tt.old, tt.new, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffLinesOverCap(t *testing.T) {
+	// Each side has 200 changed lines, so the table would be over the cap
+	var old, new strings.Builder
+	old.WriteString("start\n")
+	new.WriteString("start\n")
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&old, "old %d\n", i)
+		fmt.Fprintf(&new, "new %d\n", i)
+	}
+	old.WriteString("end")
+	new.WriteString("end")
+
+	hunks := DiffLines(old.String(), new.String())
+	if len(hunks) != 1 {
+		t.Fatalf("got %d hunks, want 1", len(hunks))
+	}
+	h := hunks[0]
+	if h.OldStart != 2 || h.OldLines != 200 || h.NewStart != 2 || h.NewLines != 200 {
+		t.Errorf("got hunk %d,%d +%d,%d; want 2,200 +2,200", h.OldStart, h.OldLines, h.NewStart, h.NewLines)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+)
+
+// Ejected players become ghosts. They can't vote, call meetings, edit
+// the shared code or use the public chat, since they know every role.
+// They talk among themselves on the ghost channel and can work on ghost
+// tasks: running the task's tests against a private copy of the code.
+// Each test an engineer ghost gets passing for the first time adds
+// ghostTaskBonus seconds to the game clock.
+
+const ghostTaskBonus = 10 // seconds per newly passing test
+
+// canReadChannel reports whether player may read chat on channel
+func canReadChannel(player *Player, channel string) bool {
+	switch channel {
+	case "":
+		return true
+	case ChatChannelImpostor:
+		return player.Role == "impostor"
+	case ChatChannelGhost:
+		return !player.IsAlive
+	}
+	return false
+}
+
+// SendToChannel delivers a private chat message to the connected players
+// who may read it
+func (r *Room) SendToChannel(channel string, data []byte) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	for client, p := range r.players {
+		if !canReadChannel(p, channel) {
+			continue
+		}
+		// A slow client is detached by Run on the next broadcast
+		client.trySend(data)
+	}
+}
+
+// rolesLocked lists every player with their role, for ghosts and the
+// end of the game. Caller must hold r.mutex.
+func (r *Room) rolesLocked() []PublicPlayer {
+	players := make([]PublicPlayer, 0, len(r.players)+len(r.detached))
+	for _, p := range r.allPlayersLocked() {
+		_, parked := r.detached[p.ID]
+		entry := r.publicPlayerLocked(p, !parked)
+		entry.Role = p.Role
+		players = append(players, entry)
+	}
+	return players
+}
+
+func (r *Room) ghostProgressLocked() GhostProgress {
+	total := 0
+	if r.currentTask != nil {
+		total = len(r.currentTask.TestCases)
+	}
+	return GhostProgress{
+		Solved:       len(r.ghostSolved),
+		Total:        total,
+		BonusSeconds: len(r.ghostSolved) * ghostTaskBonus,
+	}
+}
+
+// GhostMode is what player sees on top of the game once it is a ghost:
+// every role, the private chat it can now read, and the code to start
+// ghost tasks from
+func (r *Room) GhostMode(player *Player) GhostModeMessage {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.ghostModeLocked(player)
+}
+
+// ghostModeLocked is GhostMode for callers holding r.mutex
+func (r *Room) ghostModeLocked(player *Player) GhostModeMessage {
+	chatHistory := make([]ChatRecord, 0)
+	for _, record := range r.chatHistory {
+		if record.Channel != "" && canReadChannel(player, record.Channel) {
+			chatHistory = append(chatHistory, record)
+		}
+	}
+	return GhostModeMessage{
+		Type:         "ghost-mode",
+		Players:      r.rolesLocked(),
+		ChatMessages: chatHistory,
+		Code:         r.currentCode,
+		Progress:     r.ghostProgressLocked(),
+	}
+}
+
+// sendGhostMode tells a newly ejected player they are a ghost
+func (r *Room) sendGhostMode(player *Player) {
+	r.mutex.RLock()
+	var client *Client
+	for c, p := range r.players {
+		if p == player {
+			client = c
+			break
+		}
+	}
+	r.mutex.RUnlock()
+
+	if client != nil {
+		r.SendToClient(client, r.GhostMode(player))
+	}
+}
+
+func (c *Client) handleGhostTask(code string) {
+	if c.room == nil {
+		return
+	}
+
+	if len(code) > c.config.MaxCodeSize {
+		c.rejectTooLarge("Code", "bytes", len(code), c.config.MaxCodeSize)
+		return
+	}
+
+	room := c.room
+	room.mutex.Lock()
+	player := room.players[c]
+	if player == nil || player.IsAlive {
+		room.mutex.Unlock()
+		c.sendError(ErrCodeNotAllowed, "Only ghosts can work on ghost tasks!")
+		return
+	}
+	if (room.gameState != StatePlaying && room.gameState != StateVoting) || room.currentTask == nil {
+		room.mutex.Unlock()
+		c.sendError(ErrCodeInvalidState, "Ghost tasks are only available during a game!")
+		return
+	}
+	if room.ghostRunning[player.ID] {
+		room.mutex.Unlock()
+		c.sendError(ErrCodeInvalidState, "Your last ghost task is still running!")
+		return
+	}
+	room.ghostRunning[player.ID] = true
+	task := room.currentTask
+	room.mutex.Unlock()
+
+	go room.VerifyGhostTask(c, player, task, code)
+}
+
+// VerifyGhostTask runs a ghost's private copy against the task's tests.
+// Tests an engineer ghost passes for the first time add to the clock.
+func (r *Room) VerifyGhostTask(ghost *Client, player *Player, task *Task, code string) {
+	defer func() {
+		r.mutex.Lock()
+		delete(r.ghostRunning, player.ID)
+		r.mutex.Unlock()
+	}()
+
+	result, err := r.hub.runner.Run(context.Background(), task, task.Language, code)
+	if err != nil {
+		log.Printf("[LGTM] Test runner error for ghost task in room %s: %v", r.code, err)
+		result = &TestRunResult{Passed: false, Results: []TestResult{}, Error: "Test runner unavailable, try again."}
+	}
+
+	r.mutex.Lock()
+	if r.currentTask != task || (r.gameState != StatePlaying && r.gameState != StateVoting) {
+		// The game ended while the tests ran
+		r.mutex.Unlock()
+		return
+	}
+	newlySolved := 0
+	if player.Role != "impostor" {
+		for i, test := range result.Results {
+			if test.Passed && !r.ghostSolved[i] {
+				r.ghostSolved[i] = true
+				newlySolved++
+			}
+		}
+	}
+	r.timeRemaining += newlySolved * ghostTaskBonus
+	progress := r.ghostProgressLocked()
+	r.mutex.Unlock()
+
+	// The ghost may have left while the tests ran
+	reply, _ := json.Marshal(GhostTaskResultMessage{
+		Type:        "ghost-task-result",
+		Passed:      result.Passed,
+		Results:     result.Results,
+		Error:       result.Error,
+		NewlySolved: newlySolved,
+		Progress:    progress,
+	})
+	ghost.trySend(reply)
+
+	if newlySolved == 0 {
+		return
+	}
+	data, _ := json.Marshal(GhostProgressMessage{
+		Type:       "ghost-progress",
+		PlayerName: player.Name,
+		Progress:   progress,
+	})
+	r.broadcast <- data
+	log.Printf("👻 [LGTM] Ghost %s solved %d test(s) in room %s, +%ds", player.Name, newlySolved, r.code, newlySolved*ghostTaskBonus)
+}
@@ -0,0 +1,64 @@
+package main
+
+import "testing"
+
+func TestCanReadChannel(t *testing.T) {
+	engineer := &Player{Role: "engineer", IsAlive: true}
+	impostor := &Player{Role: "impostor", IsAlive: true}
+	engineerGhost := &Player{Role: "engineer", IsAlive: false}
+	impostorGhost := &Player{Role: "impostor", IsAlive: false}
+
+	tests := []struct {
+		player  *Player
+		channel string
+		want    bool
+	}{
+		{engineer, "", true},
+		{engineer, ChatChannelImpostor, false},
+		{engineer, ChatChannelGhost, false},
+		{impostor, ChatChannelImpostor, true},
+		{impostor, ChatChannelGhost, false},
+		{engineerGhost, "", true},
+		{engineerGhost, ChatChannelImpostor, false},
+		{engineerGhost, ChatChannelGhost, true},
+		{impostorGhost, ChatChannelImpostor, true},
+		{impostorGhost, ChatChannelGhost, true},
+		{engineer, "unknown", false},
+	}
+	for _, tt := range tests {
+		if got := canReadChannel(tt.player, tt.channel); got != tt.want {
+			t.Errorf("canReadChannel(%s alive=%v, %q) = %v, want %v", tt.player.Role, tt.player.IsAlive, tt.channel, got, tt.want)
+		}
+	}
+}
+
+func TestGhostCantEdit(t *testing.T) {
+	tests := []struct {
+		name    string
+		msgType string
+		data    interface{}
+	}{
+		{"code-update", "code-update", CodeUpdateRequest{Code: "// ghost was here"}},
+		{"code-op", "code-op", CodeOpRequest{Revision: 0, Ops: []Edit{{Type: "insert", Pos: 0, Text: "// ghost was here\n"}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			room, clients := startTestGame(t, newTestHub(t), 4)
+			ghost := clients[1]
+			player := ghost.player(room)
+			room.mutex.Lock()
+			player.IsAlive = false
+			before := room.currentCode
+			room.mutex.Unlock()
+
+			ghost.do(tt.msgType, tt.data)
+			ghost.expectError(ErrCodeInvalidState)
+
+			room.mutex.RLock()
+			defer room.mutex.RUnlock()
+			if room.currentCode != before || room.revision != 0 {
+				t.Errorf("code changed to %q at revision %d", room.currentCode, room.revision)
+			}
+		})
+	}
+}
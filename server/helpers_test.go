@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestHub is a hub with its data files in a temporary directory and
+// a one-task catalog. Its loop doesn't run; tests drive clients directly.
+func newTestHub(t *testing.T) *Hub {
+	t.Helper()
+	dir := t.TempDir()
+	config := DefaultConfig()
+	config.RecordingsDir = filepath.Join(dir, "recordings")
+	config.ProfilesFile = filepath.Join(dir, "profiles.jsonl")
+	config.IdentityKeyFile = filepath.Join(dir, "identity.key")
+	config.RatingsFile = filepath.Join(dir, "ratings.json")
+	config.ResultsDelay = Duration(time.Millisecond)
+
+	tasksMutex.Lock()
+	saved := Tasks
+	Tasks = []Task{testTask(1)}
+	tasksMutex.Unlock()
+
+	hub := NewHub(config)
+	t.Cleanup(func() {
+		for _, room := range hub.Rooms() {
+			room.Close()
+		}
+		tasksMutex.Lock()
+		Tasks = saved
+		tasksMutex.Unlock()
+	})
+	return hub
+}
+
+// testClient is a connection without a socket: messages go straight to
+// handleMessage and replies are read off the send queue
+type testClient struct {
+	*Client
+	t *testing.T
+}
+
+func newTestClient(t *testing.T, hub *Hub, id string) *testClient {
+	return &testClient{
+		Client: &Client{
+			id:      id,
+			hub:     hub,
+			config:  hub.config,
+			send:    make(chan []byte, hub.config.SendQueueSize),
+			done:    make(chan struct{}),
+			drained: make(chan struct{}, 1),
+			flood:   newFloodControl(),
+		},
+		t: t,
+	}
+}
+
+func (c *testClient) do(msgType string, data interface{}) {
+	c.t.Helper()
+	raw, err := json.Marshal(data)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	message, _ := json.Marshal(Message{Type: msgType, Data: raw})
+	c.handleMessage(message)
+}
+
+// expect skips ahead to the next message of msgType and decodes it. An
+// error reply on the way fails the test.
+func (c *testClient) expect(msgType string, v interface{}) {
+	c.t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case data := <-c.send:
+			var msg ErrorMessage
+			json.Unmarshal(data, &msg)
+			if msg.Type == "error" && msgType != "error" {
+				c.t.Fatalf("%s got error %s (%s) waiting for %s", c.id, msg.Code, msg.Message, msgType)
+			}
+			if msg.Type != msgType {
+				continue
+			}
+			if v != nil {
+				if err := json.Unmarshal(data, v); err != nil {
+					c.t.Fatal(err)
+				}
+			}
+			return
+		case <-timeout:
+			c.t.Fatalf("%s got no %s message", c.id, msgType)
+		}
+	}
+}
+
+// expectError waits for an error reply with code
+func (c *testClient) expectError(code string) {
+	c.t.Helper()
+	var msg ErrorMessage
+	c.expect("error", &msg)
+	if msg.Code != code {
+		c.t.Fatalf("%s got error %s (%s), want %s", c.id, msg.Code, msg.Message, code)
+	}
+}
+
+// newTestRoom creates a room hosted by the first of n players, all seated
+// in the lobby
+func newTestRoom(t *testing.T, hub *Hub, n int) (*Room, []*testClient) {
+	t.Helper()
+	clients := make([]*testClient, n)
+	for i := range clients {
+		clients[i] = newTestClient(t, hub, fmt.Sprintf("player-%d", i+1))
+	}
+	var created RoomJoinedMessage
+	clients[0].do("create-room", CreateRoomRequest{PlayerName: "Player 1"})
+	clients[0].expect("room-created", &created)
+	for i, c := range clients[1:] {
+		c.do("join-room", JoinRoomRequest{RoomCode: created.RoomCode, PlayerName: fmt.Sprintf("Player %d", i+2)})
+		c.expect("room-joined", nil)
+	}
+	return hub.GetRoom(created.RoomCode), clients
+}
+
+// startTestGame starts a game in a new room of n players and returns the
+// players with their roles
+func startTestGame(t *testing.T, hub *Hub, n int) (*Room, []*testClient) {
+	t.Helper()
+	room, clients := newTestRoom(t, hub, n)
+	if err := room.StartGame(); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range clients {
+		c.expect("game-started", nil)
+	}
+	return room, clients
+}
+
+// player returns the client's player, checking it is seated
+func (c *testClient) player(room *Room) *Player {
+	c.t.Helper()
+	room.mutex.RLock()
+	defer room.mutex.RUnlock()
+	player := room.players[c.Client]
+	if player == nil {
+		c.t.Fatalf("%s isn't seated in room %s", c.id, room.code)
+	}
+	return player
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+)
+
+// The host runs the lobby: only they can start the game, change settings
+// and kick players. The room's creator is the first host. When the host
+// leaves or disconnects, the connected player who joined earliest takes
+// over.
+
+var (
+	errNotHost      = errors.New("only the host can do that")
+	errKickMidGame  = errors.New("players can't be kicked during a game")
+	errNoSuchPlayer = errors.New("player is not in the room")
+	errKickSelf     = errors.New("the host can't kick themselves")
+)
+
+// passHostLocked hands the host to the longest-present connected player
+// if the current host isn't connected. It returns the new host, or nil
+// if the host didn't change. Caller must hold r.mutex.
+func (r *Room) passHostLocked() *Player {
+	connected := make([]*Player, 0, len(r.players))
+	for _, p := range r.players {
+		if p.ID == r.hostID {
+			return nil
+		}
+		connected = append(connected, p)
+	}
+	if len(connected) == 0 {
+		return nil
+	}
+
+	sort.Slice(connected, func(i, j int) bool {
+		return connected[i].joined < connected[j].joined
+	})
+	r.hostID = connected[0].ID
+	log.Printf("👑 [LGTM] %s is now the host of room %s", connected[0].Name, r.code)
+	return connected[0]
+}
+
+// TransferHost lets the host hand the room to another connected player
+func (r *Room) TransferHost(host *Client, playerID string) (*Player, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.hostID != host.id {
+		return nil, errNotHost
+	}
+	for _, p := range r.players {
+		if p.ID == playerID {
+			r.hostID = p.ID
+			return p, nil
+		}
+	}
+	return nil, errNoSuchPlayer
+}
+
+// KickPlayer removes a player from the lobby for good and returns their
+// client so they can be told. The ban is on the player ID, which for a
+// signed-in player is their profile. Anonymous players get a new ID with
+// every connection, so kicking one only removes them.
+func (r *Room) KickPlayer(host *Client, playerID string) (*Client, *Player, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.hostID != host.id {
+		return nil, nil, errNotHost
+	}
+	if r.gameState == StatePlaying || r.gameState == StateVoting {
+		return nil, nil, errKickMidGame
+	}
+	if playerID == host.id {
+		return nil, nil, errKickSelf
+	}
+	for client, p := range r.players {
+		if p.ID == playerID {
+			r.kicked[p.ID] = true
+			r.detachLocked(client)
+			return client, p, nil
+		}
+	}
+	return nil, nil, errNoSuchPlayer
+}
+
+func (c *Client) handleKickPlayer(playerID string) {
+	if c.room == nil {
+		return
+	}
+
+	room := c.room
+	target, player, err := room.KickPlayer(c, playerID)
+	switch {
+	case errors.Is(err, errNotHost):
+		c.sendError(ErrCodeNotAllowed, "Only the host can kick players!")
+		return
+	case errors.Is(err, errKickSelf):
+		c.sendError(ErrCodeNotAllowed, "You can't kick yourself!")
+		return
+	case errors.Is(err, errKickMidGame):
+		c.sendError(ErrCodeInvalidState, "Players can only be kicked between games!")
+		return
+	case err != nil:
+		c.sendError(ErrCodeNotFound, "Player not found!")
+		return
+	}
+
+	data, _ := json.Marshal(KickedMessage{
+		Type:     "kicked",
+		RoomCode: room.code,
+		Message:  "You were removed from the room by the host.",
+	})
+	target.trySend(data)
+	room.BroadcastPlayerList()
+
+	log.Printf("👢 [LGTM] %s was kicked from room %s", player.Name, room.code)
+}
+
+func (c *Client) handleTransferHost(playerID string) {
+	if c.room == nil {
+		return
+	}
+
+	player, err := c.room.TransferHost(c, playerID)
+	if errors.Is(err, errNotHost) {
+		c.sendError(ErrCodeNotAllowed, "Only the host can hand over the room!")
+		return
+	}
+	if err != nil {
+		c.sendError(ErrCodeNotFound, "Player not found!")
+		return
+	}
+	c.room.BroadcastPlayerList()
+
+	log.Printf("👑 [LGTM] %s is now the host of room %s", player.Name, c.room.code)
+}
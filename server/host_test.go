@@ -0,0 +1,76 @@
+package main
+
+import "testing"
+
+func TestKickPlayer(t *testing.T) {
+	tests := []struct {
+		name   string
+		kicker int // index of the client asking
+		target string
+		start  bool
+		want   string // error code, "" if the kick goes through
+	}{
+		{"host kicks", 0, "player-2", false, ""},
+		{"not the host", 1, "player-3", false, ErrCodeNotAllowed},
+		{"host kicks themselves", 0, "player-1", false, ErrCodeNotAllowed},
+		{"nobody by that ID", 0, "player-9", false, ErrCodeNotFound},
+		{"during a game", 0, "player-2", true, ErrCodeInvalidState},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hub := newTestHub(t)
+			room, clients := newTestRoom(t, hub, 3)
+			if tt.start {
+				if err := room.StartGame(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			clients[tt.kicker].do("kick-player", KickPlayerRequest{PlayerID: tt.target})
+			if tt.want != "" {
+				clients[tt.kicker].expectError(tt.want)
+				if len(room.GetPlayers()) != 3 {
+					t.Errorf("got %d players, want 3", len(room.GetPlayers()))
+				}
+				return
+			}
+
+			kicked := clients[1]
+			kicked.expect("kicked", nil)
+			if kicked.room != nil || len(room.GetPlayers()) != 2 {
+				t.Errorf("kicked player still seated; %d players left", len(room.GetPlayers()))
+			}
+		})
+	}
+}
+
+func TestKickedPlayerCantRejoin(t *testing.T) {
+	hub := newTestHub(t)
+	room, clients := newTestRoom(t, hub, 3)
+	clients[0].do("kick-player", KickPlayerRequest{PlayerID: "player-2"})
+	clients[1].expect("kicked", nil)
+
+	// The same player ID, as a signed-in player has on any connection
+	again := newTestClient(t, hub, "player-2")
+	again.do("join-room", JoinRoomRequest{RoomCode: room.code, PlayerName: "Player 2"})
+	again.expectError(ErrCodeNotAllowed)
+
+	// An anonymous player reconnecting gets a new ID, so only the kick stuck
+	fresh := newTestClient(t, hub, "player-2b")
+	fresh.do("join-room", JoinRoomRequest{RoomCode: room.code, PlayerName: "Player 2"})
+	fresh.expect("room-joined", nil)
+}
+
+func TestHostPassesOnLeave(t *testing.T) {
+	hub := newTestHub(t)
+	room, clients := newTestRoom(t, hub, 3)
+	clients[0].do("transfer-host", TransferHostRequest{PlayerID: "player-3"})
+	clients[0].expect("player-list", nil)
+
+	room.RemovePlayer(clients[2].Client)
+	room.mutex.RLock()
+	defer room.mutex.RUnlock()
+	if room.hostID != "player-1" {
+		t.Errorf("host passed to %s, want the earliest joiner, player-1", room.hostID)
+	}
+}
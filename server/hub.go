@@ -3,23 +3,71 @@ package main
 import (
 	"log"
 	"math/rand"
+	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/gorilla/websocket"
 )
 
 type Hub struct {
+	config     *Config
+	upgrader   websocket.Upgrader
 	rooms      map[string]*Room
+	clients    map[*Client]bool
 	register   chan *Client
 	unregister chan *Client
+	runner     TestRunner
+	secret     []byte // signs resume tokens
+	recordings *RecordingStore
+	metrics    *Metrics
+	matchmaker *Matchmaker
+	identity   *Identity
+	ratings    *Ratings
+	draining   atomic.Bool
+	drainUntil time.Time // set once draining starts
 	mutex      sync.RWMutex
 }
 
-func NewHub() *Hub {
-	return &Hub{
+func NewHub(config *Config) *Hub {
+	hub := &Hub{
+		config: config,
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  config.ReadBufferSize,
+			WriteBufferSize: config.WriteBufferSize,
+			Subprotocols:    Subprotocols(),
+			CheckOrigin: func(r *http.Request) bool {
+				return true // Allow all origins for development
+			},
+		},
 		rooms:      make(map[string]*Room),
+		clients:    make(map[*Client]bool),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
+		runner:     NewSubprocessRunner(config),
+		secret:     newSessionSecret(),
+		recordings: NewRecordingStore(config.RecordingsDir),
+		metrics:    NewMetrics(),
+	}
+	hub.matchmaker = NewMatchmaker(hub)
+
+	profiles, err := NewFileProfileStore(config.ProfilesFile)
+	if err != nil {
+		log.Fatalf("[LGTM] Failed to load profiles from %s: %v", config.ProfilesFile, err)
+	}
+	secret, err := loadIdentitySecret(config.IdentityKeyFile)
+	if err != nil {
+		log.Fatalf("[LGTM] Failed to load identity key %s: %v", config.IdentityKeyFile, err)
 	}
+	hub.identity = NewIdentity(profiles, secret, config.IdentityTokenTTL.D())
+
+	ratings, err := NewFileRatingStore(config.RatingsFile)
+	if err != nil {
+		log.Fatalf("[LGTM] Failed to load ratings from %s: %v", config.RatingsFile, err)
+	}
+	hub.ratings = NewRatings(ratings)
+	return hub
 }
 
 func (h *Hub) Run() {
@@ -27,19 +75,35 @@ func (h *Hub) Run() {
 		select {
 		case client := <-h.register:
 			// Client connected, waiting for room action
+			h.mutex.Lock()
+			h.clients[client] = true
+			h.mutex.Unlock()
 			log.Printf("Client registered: %s", client.id)
+			if h.IsDraining() {
+				client.trySend(h.drainingMessage())
+			}
 
 		case client := <-h.unregister:
+			// Before taking the lock: a broken-up ready check deletes its room
+			h.matchmaker.Remove(client)
 			h.mutex.Lock()
+			delete(h.clients, client)
 			room := client.room
 			if room != nil {
-				room.RemovePlayer(client)
-				if len(room.players) == 0 {
+				room.DetachPlayer(client)
+				if room.IsEmpty() {
 					delete(h.rooms, room.code)
+					go room.Close()
 				} else {
+					// Whoever left may have been the last one holding up a rematch
+					room.CheckRematch()
 					room.BroadcastPlayerList()
 				}
 			}
+			if spectating := client.spectating; spectating != nil {
+				spectating.RemoveSpectator(client)
+				spectating.BroadcastPlayerList()
+			}
 			// Cleanup will be handled by client.cleanup() when readPump/writePump exit
 			// But if they haven't called cleanup yet, we call it here
 			client.cleanup()
@@ -64,6 +128,18 @@ func (h *Hub) GetRoom(code string) *Room {
 	return h.rooms[code]
 }
 
+// Rooms returns a snapshot of every room
+func (h *Hub) Rooms() []*Room {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	rooms := make([]*Room, 0, len(h.rooms))
+	for _, room := range h.rooms {
+		rooms = append(rooms, room)
+	}
+	return rooms
+}
+
 func (h *Hub) DeleteRoom(code string) {
 	h.mutex.Lock()
 	defer h.mutex.Unlock()
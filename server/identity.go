@@ -0,0 +1,377 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// Players get a stable ID by signing in: as a guest with just a display
+// name, or registered with a username and password. Either way the
+// server hands back a signed identity token; presenting it on the
+// websocket upgrade makes the profile ID the player ID, so stats,
+// reconnects and kicks follow the person rather than the connection.
+// Connections without a token still get a throwaway ID.
+
+const (
+	maxDisplayNameLength = 24
+	minPasswordLength    = 8
+	maxPasswordLength    = 128
+	passwordIterations   = 100000
+	identityTokenPrefix  = "identity"
+)
+
+var (
+	errIdentityTokenExpired = errors.New("identity token expired")
+	errWrongCredentials     = errors.New("wrong username or password")
+	errAlreadyRegistered    = errors.New("profile is already registered")
+
+	usernamePattern = regexp.MustCompile(`^[a-z0-9_-]{3,20}$`)
+)
+
+// Identity issues and checks identity tokens for the profiles in a store
+type Identity struct {
+	store   ProfileStore
+	secret  []byte
+	ttl     time.Duration
+	limiter *ipRateLimiter
+}
+
+func NewIdentity(store ProfileStore, secret []byte, ttl time.Duration) *Identity {
+	return &Identity{
+		store:   store,
+		secret:  secret,
+		ttl:     ttl,
+		limiter: newIPRateLimiter(identityRateLimits),
+	}
+}
+
+// loadIdentitySecret reads the key identity tokens are signed with,
+// creating it on first run so tokens survive restarts. Anyone who can
+// read the key can forge tokens, so only the server's user may; the
+// runner sandbox runs submissions as another user.
+func loadIdentitySecret(path string) ([]byte, error) {
+	secret, err := os.ReadFile(path)
+	if err == nil {
+		if len(secret) < 32 {
+			return nil, errors.New(path + " is too short to be a signing key")
+		}
+		if err := makePrivate(path); err != nil {
+			return nil, err
+		}
+		return secret, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	secret = make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, secret, 0o600); err != nil {
+		return nil, err
+	}
+	log.Printf("🔑 [LGTM] Created identity signing key %s", path)
+	return secret, nil
+}
+
+// makePrivate takes group and other permissions off a file the server
+// keeps to itself, in case it was created or copied in with looser ones
+func makePrivate(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Mode().Perm()&0o077 == 0 {
+		return nil
+	}
+	log.Printf("🔒 [LGTM] %s was readable by other users, restricting it to the server's", path)
+	return os.Chmod(path, 0o600)
+}
+
+// IssueToken signs a profile ID with an expiry
+func (id *Identity) IssueToken(profileID string) (string, time.Time) {
+	expires := time.Now().Add(id.ttl)
+	payload := identityTokenPrefix + ":" + profileID + ":" + strconv.FormatInt(expires.Unix(), 10)
+	return signToken(id.secret, payload), expires
+}
+
+// ParseToken verifies an identity token and returns its profile
+func (id *Identity) ParseToken(token string) (*Profile, error) {
+	payload, err := verifyToken(id.secret, token)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Split(payload, ":")
+	if len(parts) != 3 || parts[0] != identityTokenPrefix {
+		return nil, errInvalidToken
+	}
+	expires, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return nil, errInvalidToken
+	}
+	if time.Now().Unix() > expires {
+		return nil, errIdentityTokenExpired
+	}
+	return id.store.Get(parts[1])
+}
+
+// Authenticate finds the profile for an HTTP request's token, given as
+// "Authorization: Bearer ..." or, for browsers opening a websocket, as
+// the token query parameter. It returns nil with no error when there is
+// no token.
+func (id *Identity) Authenticate(r *http.Request) (*Profile, error) {
+	token := r.URL.Query().Get("token")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	}
+	if token == "" {
+		return nil, nil
+	}
+	return id.ParseToken(token)
+}
+
+// CreateGuest makes a profile with only a display name
+func (id *Identity) CreateGuest(displayName string) (*Profile, error) {
+	profile := &Profile{
+		ID:          uuid.New().String(),
+		DisplayName: displayName,
+		CreatedAt:   time.Now().UnixMilli(),
+	}
+	if err := id.store.Put(profile); err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
+
+// Register gives a profile a username and password. A guest registering
+// keeps its ID, and with it its history; otherwise profile is nil and a
+// new one is made.
+func (id *Identity) Register(profile *Profile, username, password, displayName string) (*Profile, error) {
+	if profile == nil {
+		profile = &Profile{
+			ID:        uuid.New().String(),
+			CreatedAt: time.Now().UnixMilli(),
+		}
+	} else if !profile.Guest() {
+		return nil, errAlreadyRegistered
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	if displayName != "" {
+		profile.DisplayName = displayName
+	}
+	if profile.DisplayName == "" {
+		profile.DisplayName = username
+	}
+	profile.Username = username
+	profile.PasswordSalt = salt
+	profile.PasswordHash = hashAccountPassword(salt, password)
+
+	if err := id.store.Put(profile); err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
+
+// Login checks a registered player's password
+func (id *Identity) Login(username, password string) (*Profile, error) {
+	profile, err := id.store.GetByUsername(username)
+	if errors.Is(err, errProfileNotFound) {
+		// Spend the same time as a real check so usernames can't be probed
+		hashAccountPassword(make([]byte, 16), password)
+		return nil, errWrongCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare(hashAccountPassword(profile.PasswordSalt, password), profile.PasswordHash) != 1 {
+		return nil, errWrongCredentials
+	}
+	return profile, nil
+}
+
+// hashAccountPassword is PBKDF2-HMAC-SHA256 with one 32-byte block.
+// Unlike room passwords these outlive the game, so they are stretched.
+func hashAccountPassword(salt []byte, password string) []byte {
+	mac := hmac.New(sha256.New, []byte(password))
+	block := make([]byte, 4)
+	binary.BigEndian.PutUint32(block, 1)
+	mac.Write(salt)
+	mac.Write(block)
+	u := mac.Sum(nil)
+	key := append([]byte{}, u...)
+	for i := 1; i < passwordIterations; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+	return key
+}
+
+// playerName is the name a client asked for, or its profile's display
+// name if it didn't ask for one
+func (c *Client) playerName(requested string) string {
+	if strings.TrimSpace(requested) == "" && c.profile != nil {
+		return c.profile.DisplayName
+	}
+	return requested
+}
+
+func validDisplayName(name string) bool {
+	name = strings.TrimSpace(name)
+	return name != "" && utf8.RuneCountInString(name) <= maxDisplayNameLength
+}
+
+// IdentityResponse is what the identity endpoints return on success
+type IdentityResponse struct {
+	Token     string        `json:"token"`
+	ExpiresAt int64         `json:"expiresAt"` // Unix ms
+	Profile   PublicProfile `json:"profile"`
+}
+
+// ServeIdentity handles the /api/identity/ endpoints:
+//
+//	POST /api/identity/guest     {displayName}
+//	POST /api/identity/register  {username, password, displayName}
+//	POST /api/identity/login     {username, password}
+//	GET  /api/identity/me
+//
+// register upgrades the guest profile whose token comes with the request.
+func ServeIdentity(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	action := strings.TrimPrefix(r.URL.Path, "/api/identity/")
+	wantMethod := http.MethodPost
+	if action == "me" {
+		wantMethod = http.MethodGet
+	}
+	if r.Method != wantMethod {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, known := identityRateLimits[action]; !known {
+		http.NotFound(w, r)
+		return
+	}
+	if ok, wait := hub.identity.limiter.allow(remoteIP(r, hub.config.ClientIPHeader), action); !ok {
+		hub.metrics.messagesRejected.Add(1, "identity-"+action, "rate_limited")
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		http.Error(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+
+	current, err := hub.identity.Authenticate(r)
+	if err != nil {
+		http.Error(w, "invalid or expired token", http.StatusUnauthorized)
+		return
+	}
+
+	var body struct {
+		DisplayName string `json:"displayName"`
+		Username    string `json:"username"`
+		Password    string `json:"password"`
+	}
+	if r.Method == http.MethodPost {
+		r.Body = http.MaxBytesReader(w, r.Body, 4096)
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+			return
+		}
+	}
+	body.Username = strings.ToLower(strings.TrimSpace(body.Username))
+	body.DisplayName = strings.TrimSpace(body.DisplayName)
+
+	var profile *Profile
+	status := http.StatusOK
+	switch action {
+	case "guest":
+		if !validDisplayName(body.DisplayName) {
+			http.Error(w, "displayName must be 1 to 24 characters", http.StatusBadRequest)
+			return
+		}
+		profile, err = hub.identity.CreateGuest(body.DisplayName)
+		status = http.StatusCreated
+
+	case "register":
+		if !usernamePattern.MatchString(body.Username) {
+			http.Error(w, "username must be 3 to 20 lowercase letters, digits, _ or -", http.StatusBadRequest)
+			return
+		}
+		if len(body.Password) < minPasswordLength || len(body.Password) > maxPasswordLength {
+			http.Error(w, "password must be 8 to 128 characters", http.StatusBadRequest)
+			return
+		}
+		if body.DisplayName != "" && !validDisplayName(body.DisplayName) {
+			http.Error(w, "displayName must be 1 to 24 characters", http.StatusBadRequest)
+			return
+		}
+		profile, err = hub.identity.Register(current, body.Username, body.Password, body.DisplayName)
+		status = http.StatusCreated
+
+	case "login":
+		profile, err = hub.identity.Login(body.Username, body.Password)
+
+	case "me":
+		if current == nil {
+			http.Error(w, "no token", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(current.Public())
+		return
+
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	switch {
+	case errors.Is(err, errUsernameTaken):
+		http.Error(w, "username is taken", http.StatusConflict)
+		return
+	case errors.Is(err, errAlreadyRegistered):
+		http.Error(w, "already registered, log in instead", http.StatusConflict)
+		return
+	case errors.Is(err, errWrongCredentials):
+		http.Error(w, "wrong username or password", http.StatusUnauthorized)
+		return
+	case err != nil:
+		log.Printf("[LGTM] Identity %s failed: %v", action, err)
+		http.Error(w, "failed to save profile", http.StatusInternalServerError)
+		return
+	}
+
+	token, expires := hub.identity.IssueToken(profile.ID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(IdentityResponse{
+		Token:     token,
+		ExpiresAt: expires.UnixMilli(),
+		Profile:   profile.Public(),
+	})
+	log.Printf("🪪 [LGTM] Identity %s: %s (%s)", action, profile.DisplayName, profile.ID)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHashAccountPassword(t *testing.T) {
+	// PBKDF2-HMAC-SHA256 of "password" with salt "salt" and 100000
+	// iterations, from Python's hashlib.pbkdf2_hmac
+	const want = "0394a2ede332c9a13eb82e9b24631604c31df978b4e2f0fbd2c549944f9d79a5"
+	if passwordIterations != 100000 {
+		t.Fatalf("passwordIterations is %d; update the expected hash", passwordIterations)
+	}
+	if got := hex.EncodeToString(hashAccountPassword([]byte("salt"), "password")); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func newTestIdentity(t *testing.T, ttl time.Duration) *Identity {
+	t.Helper()
+	store, err := NewFileProfileStore(filepath.Join(t.TempDir(), "profiles.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewIdentity(store, []byte("0123456789abcdef0123456789abcdef"), ttl)
+}
+
+func TestIdentityToken(t *testing.T) {
+	id := newTestIdentity(t, time.Hour)
+	guest, err := id.CreateGuest("Guest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _ := id.IssueToken(guest.ID)
+	expired, _ := newTestIdentity(t, -time.Minute).IssueToken(guest.ID)
+	otherKey, _ := NewIdentity(nil, []byte("another secret"), time.Hour).IssueToken(guest.ID)
+	unknown, _ := id.IssueToken("nobody")
+
+	tests := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"valid", token, nil},
+		{"expired", expired, errIdentityTokenExpired},
+		{"signed with another key", otherKey, errInvalidToken},
+		{"resume token", signToken(id.secret, "ABCD:"+guest.ID), errInvalidToken},
+		{"unknown profile", unknown, errProfileNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profile, err := id.ParseToken(tt.token)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+			if err == nil && profile.ID != guest.ID {
+				t.Errorf("got profile %s, want %s", profile.ID, guest.ID)
+			}
+		})
+	}
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	id := newTestIdentity(t, time.Hour)
+	guest, err := id.CreateGuest("Guest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	registered, err := id.Register(guest, "alice", "correct horse", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if registered.ID != guest.ID || registered.DisplayName != "Guest" {
+		t.Errorf("registering a guest gave %+v, want its ID and name kept", registered)
+	}
+	if _, err := id.Register(registered, "alice2", "correct horse", ""); !errors.Is(err, errAlreadyRegistered) {
+		t.Errorf("registering twice: got %v, want %v", err, errAlreadyRegistered)
+	}
+
+	tests := []struct {
+		username, password string
+		err                error
+	}{
+		{"alice", "correct horse", nil},
+		{"alice", "wrong horse", errWrongCredentials},
+		{"bob", "correct horse", errWrongCredentials},
+	}
+	for _, tt := range tests {
+		profile, err := id.Login(tt.username, tt.password)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("Login(%q, %q): got %v, want %v", tt.username, tt.password, err, tt.err)
+			continue
+		}
+		if err == nil && profile.ID != guest.ID {
+			t.Errorf("Login(%q): got profile %s, want %s", tt.username, profile.ID, guest.ID)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+const defaultLanguage = "javascript"
+
+// Language describes how to run submissions in one language. Harnesses
+// read a harnessInput on stdin, load the player's code from SolutionFile
+// and print a harnessOutput on stdout. FUNCTION_NAME in the harness is
+// replaced with the task's function name. Harnesses run in the same
+// process as the code, so they are not a security boundary; the
+// runner's sandbox is.
+type Language struct {
+	Name         string
+	EditorMode   string // Monaco language id
+	SolutionFile string
+	HarnessFile  string
+	Harness      string
+	// Build, if set, compiles the program before it runs
+	Build func(dir string) []string
+	Run   func(dir string, memoryMB int) []string
+	Env   func(dir string) []string
+	// LimitAddressSpace applies ulimit -v. V8 and the Go runtime reserve
+	// far more address space than they use, so node relies on its heap
+	// flag and the Go harness watches its own heap instead.
+	LimitAddressSpace bool
+}
+
+var Languages = map[string]*Language{
+	"javascript": {
+		Name:         "javascript",
+		EditorMode:   "javascript",
+		SolutionFile: "solution.js",
+		HarnessFile:  "harness.js",
+		Harness:      jsHarness,
+		Run: func(dir string, memoryMB int) []string {
+			return []string{"node", "--max-old-space-size=" + strconv.Itoa(memoryMB), "harness.js"}
+		},
+		Env: func(dir string) []string {
+			return []string{"NODE_OPTIONS="}
+		},
+	},
+	"python": {
+		Name:         "python",
+		EditorMode:   "python",
+		SolutionFile: "solution.py",
+		HarnessFile:  "harness.py",
+		Harness:      pythonHarness,
+		Run: func(dir string, memoryMB int) []string {
+			return []string{"python3", "-I", "harness.py"}
+		},
+		Env: func(dir string) []string {
+			return []string{"PYTHONDONTWRITEBYTECODE=1"}
+		},
+		LimitAddressSpace: true,
+	},
+	"go": {
+		Name:         "go",
+		EditorMode:   "go",
+		SolutionFile: "solution.go",
+		HarnessFile:  "harness.go",
+		Harness:      goHarness,
+		Build: func(dir string) []string {
+			return []string{"go", "build", "-o", "program", "harness.go", "solution.go"}
+		},
+		Run: func(dir string, memoryMB int) []string {
+			return []string{"./program"}
+		},
+		Env: func(dir string) []string {
+			// Share the build cache between runs; never touch the network
+			cache := filepath.Join(os.TempDir(), "lgtm-gocache")
+			return []string{
+				"HOME=" + dir,
+				"GOCACHE=" + cache,
+				"GOPATH=" + filepath.Join(dir, "gopath"),
+				"GOPROXY=off",
+				"GOTOOLCHAIN=local",
+				"CGO_ENABLED=0",
+			}
+		},
+	},
+}
+
+// LanguageNames lists the supported languages in a stable order
+func LanguageNames() []string {
+	names := make([]string, 0, len(Languages))
+	for name := range Languages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// editorMode returns the Monaco mode for a language
+func editorMode(language string) string {
+	if lang := Languages[language]; lang != nil {
+		return lang.EditorMode
+	}
+	return ""
+}
+
+const jsHarness = `'use strict';
+const fs = require('fs');
+const vm = require('vm');
+
+function main(input) {
+  const { functionName, testCases, testTimeoutMs } = input;
+  const code = fs.readFileSync('solution.js', 'utf8');
+  const sandbox = vm.createContext(Object.create(null));
+  try {
+    vm.runInContext(code + '\n;globalThis.__fn = typeof ' + functionName +
+      " === 'function' ? " + functionName + ' : null;', sandbox, { timeout: testTimeoutMs });
+  } catch (e) {
+    return { error: 'Syntax Error: ' + (e && e.message) };
+  }
+  if (!sandbox.__fn) {
+    return { error: 'Function "' + functionName + '" not found. Make sure your function is named correctly.' };
+  }
+
+  // Test cases share one context, in order, because tasks like the
+  // shopping cart keep state between calls
+  const results = [];
+  for (const tc of testCases) {
+    // Only text crosses into the context. A host object would hand the
+    // code this realm's Function constructor, and with it require.
+    sandbox.__input = JSON.stringify(tc.input);
+    const start = process.hrtime.bigint();
+    try {
+      const raw = vm.runInContext('JSON.stringify(__fn(JSON.parse(__input)))', sandbox, { timeout: testTimeoutMs });
+      results.push({ actual: raw === undefined ? null : JSON.parse(raw),
+        executionTime: Number(process.hrtime.bigint() - start) / 1e6 });
+    } catch (e) {
+      if (e && e.code === 'ERR_SCRIPT_EXECUTION_TIMEOUT') {
+        results.push({ timedOut: true });
+      } else {
+        results.push({ error: String(e && e.message) });
+      }
+    }
+  }
+  return { results };
+}
+
+let data = '';
+process.stdin.setEncoding('utf8');
+process.stdin.on('data', (chunk) => { data += chunk; });
+process.stdin.on('end', () => {
+  process.stdout.write(JSON.stringify(main(JSON.parse(data))));
+});
+`
+
+const pythonHarness = `import copy
+import json
+import signal
+import sys
+import time
+
+
+class TestTimeout(Exception):
+    pass
+
+
+def on_alarm(signum, frame):
+    raise TestTimeout()
+
+
+def main(data):
+    namespace = {"__name__": "solution"}
+    try:
+        with open("solution.py") as f:
+            exec(compile(f.read(), "solution.py", "exec"), namespace)
+    except Exception as e:
+        return {"error": "Syntax Error: %s" % e}
+
+    fn = namespace.get(data["functionName"])
+    if not callable(fn):
+        return {"error": 'Function "%s" not found. Make sure your function is named correctly.' % data["functionName"]}
+
+    signal.signal(signal.SIGALRM, on_alarm)
+    timeout = data["testTimeoutMs"] / 1000.0
+    results = []
+    for tc in data["testCases"]:
+        start = time.perf_counter()
+        signal.setitimer(signal.ITIMER_REAL, timeout)
+        try:
+            # Round-trip now; later calls may mutate what was returned
+            actual = json.loads(json.dumps(fn(copy.deepcopy(tc["input"]))))
+            results.append({"actual": actual, "executionTime": (time.perf_counter() - start) * 1000})
+        except TestTimeout:
+            results.append({"timedOut": True})
+        except Exception as e:
+            results.append({"error": "%s: %s" % (type(e).__name__, e)})
+        finally:
+            signal.setitimer(signal.ITIMER_REAL, 0)
+    return {"results": results}
+
+
+sys.stdout.write(json.dumps(main(json.load(sys.stdin))))
+`
+
+// goHarness calls a function with the signature
+// func FUNCTION_NAME(input interface{}) interface{}
+// Inputs arrive as decoded JSON: float64, string, bool, []interface{}
+// and map[string]interface{}.
+const goHarness = `package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+)
+
+type harnessResult struct {
+	Actual        json.RawMessage ` + "`json:\"actual,omitempty\"`" + `
+	Error         string          ` + "`json:\"error,omitempty\"`" + `
+	TimedOut      bool            ` + "`json:\"timedOut,omitempty\"`" + `
+	ExecutionTime float64         ` + "`json:\"executionTime\"`" + `
+}
+
+func runTest(input interface{}) (result harnessResult) {
+	defer func() {
+		if r := recover(); r != nil {
+			result = harnessResult{Error: fmt.Sprint(r)}
+		}
+	}()
+	start := time.Now()
+	actual := FUNCTION_NAME(input)
+	result.ExecutionTime = float64(time.Since(start).Microseconds()) / 1000
+	// Marshal now; later calls may mutate what was returned
+	raw, err := json.Marshal(actual)
+	if err != nil {
+		return harnessResult{Error: err.Error()}
+	}
+	result.Actual = raw
+	return result
+}
+
+// watchMemory exits without output once the heap passes the limit, which
+// the server reports as a resource limit
+func watchMemory(limitMB int64) {
+	var stats runtime.MemStats
+	for range time.Tick(10 * time.Millisecond) {
+		runtime.ReadMemStats(&stats)
+		if int64(stats.HeapAlloc) > limitMB<<20 {
+			os.Exit(3)
+		}
+	}
+}
+
+func main() {
+	var data struct {
+		TestCases []struct {
+			Input json.RawMessage ` + "`json:\"input\"`" + `
+		} ` + "`json:\"testCases\"`" + `
+		TestTimeoutMs int64 ` + "`json:\"testTimeoutMs\"`" + `
+		MemoryLimitMB int64 ` + "`json:\"memoryLimitMB\"`" + `
+	}
+	if err := json.NewDecoder(os.Stdin).Decode(&data); err != nil {
+		panic(err)
+	}
+	go watchMemory(data.MemoryLimitMB)
+
+	results := make([]harnessResult, 0, len(data.TestCases))
+	for _, tc := range data.TestCases {
+		var input interface{}
+		json.Unmarshal(tc.Input, &input)
+
+		done := make(chan harnessResult, 1)
+		go func() { done <- runTest(input) }()
+		select {
+		case r := <-done:
+			results = append(results, r)
+		case <-time.After(time.Duration(data.TestTimeoutMs) * time.Millisecond):
+			// A runaway goroutine can't be stopped, so end the run here
+			results = append(results, harnessResult{TimedOut: true})
+			json.NewEncoder(os.Stdout).Encode(map[string]interface{}{"results": results})
+			os.Exit(0)
+		}
+	}
+	json.NewEncoder(os.Stdout).Encode(map[string]interface{}{"results": results})
+}
+`
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultLeaderboardSize = 50
+	maxLeaderboardSize     = 100
+	defaultMatchHistory    = 20
+	maxMatchHistory        = 100
+)
+
+// leaderboardWindows are the time windows the leaderboard can be
+// limited to; zero means all time
+var leaderboardWindows = map[string]time.Duration{
+	"all": 0,
+	"24h": 24 * time.Hour,
+	"7d":  7 * 24 * time.Hour,
+	"30d": 30 * 24 * time.Hour,
+}
+
+// LeaderboardEntry is one player's line on the leaderboard. Games and
+// wins only count the chosen window; the rating is always current.
+type LeaderboardEntry struct {
+	Rank     int     `json:"rank"`
+	PlayerID string  `json:"playerId"`
+	Name     string  `json:"name"`
+	Rating   float64 `json:"rating"`
+	Games    int     `json:"games"`
+	Wins     int     `json:"wins"`
+	WinRate  float64 `json:"winRate"`
+}
+
+// RoleStats is a player's record in one role, or overall
+type RoleStats struct {
+	Rating  float64 `json:"rating,omitempty"` // not set for overall
+	Games   int     `json:"games"`
+	Wins    int     `json:"wins"`
+	WinRate float64 `json:"winRate"`
+}
+
+// PlayerStats is what /api/players/{id} returns
+type PlayerStats struct {
+	PlayerID string         `json:"playerId"`
+	Name     string         `json:"name"`
+	Engineer RoleStats      `json:"engineer"`
+	Impostor RoleStats      `json:"impostor"`
+	Overall  RoleStats      `json:"overall"`
+	Matches  []*MatchRecord `json:"matches"` // newest first
+}
+
+func winRate(wins, games int) float64 {
+	if games == 0 {
+		return 0
+	}
+	return float64(wins) / float64(games)
+}
+
+func roleStats(rating RoleRating) RoleStats {
+	return RoleStats{
+		Rating:  rating.Rating,
+		Games:   rating.Games,
+		Wins:    rating.Wins,
+		WinRate: winRate(rating.Wins, rating.Games),
+	}
+}
+
+// Leaderboard ranks everyone rated in role by their current rating,
+// counting only games in the last window if it isn't zero
+func (rt *Ratings) Leaderboard(role string, window time.Duration, limit int) ([]LeaderboardEntry, error) {
+	all, err := rt.store.All()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]LeaderboardEntry, 0, len(all))
+	if window == 0 {
+		for _, p := range all {
+			rating := p.Role(role)
+			if rating.Games == 0 {
+				continue
+			}
+			entries = append(entries, LeaderboardEntry{
+				PlayerID: p.PlayerID,
+				Name:     p.Name,
+				Rating:   rating.Rating,
+				Games:    rating.Games,
+				Wins:     rating.Wins,
+			})
+		}
+	} else {
+		matches, err := rt.store.Matches("", time.Now().Add(-window), 0)
+		if err != nil {
+			return nil, err
+		}
+		byID := make(map[string]*LeaderboardEntry)
+		for _, match := range matches {
+			for _, mp := range match.Players {
+				if !mp.Rated || mp.Role != role {
+					continue
+				}
+				entry, ok := byID[mp.ID]
+				if !ok {
+					entry = &LeaderboardEntry{PlayerID: mp.ID}
+					byID[mp.ID] = entry
+				}
+				entry.Games++
+				if mp.Won {
+					entry.Wins++
+				}
+			}
+		}
+		for _, p := range all {
+			if entry, ok := byID[p.PlayerID]; ok {
+				entry.Name = p.Name
+				entry.Rating = p.Role(role).Rating
+				entries = append(entries, *entry)
+			}
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Rating != b.Rating {
+			return a.Rating > b.Rating
+		}
+		if a.Games != b.Games {
+			return a.Games > b.Games
+		}
+		return a.PlayerID < b.PlayerID
+	})
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	for i := range entries {
+		entries[i].Rank = i + 1
+		entries[i].WinRate = winRate(entries[i].Wins, entries[i].Games)
+	}
+	return entries, nil
+}
+
+// Stats returns a player's ratings and their latest matches. A profile
+// that hasn't finished a game yet gets starting ratings; ok is false if
+// the player is unknown altogether.
+func (rt *Ratings) Stats(profiles ProfileStore, playerID string, limit int) (*PlayerStats, bool, error) {
+	ratings, err := rt.store.Get(playerID)
+	if errors.Is(err, errRatingsNotFound) {
+		profile, err := profiles.Get(playerID)
+		if errors.Is(err, errProfileNotFound) {
+			return nil, false, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		ratings = newPlayerRatings(playerID, profile.DisplayName)
+	} else if err != nil {
+		return nil, false, err
+	}
+
+	// The current display name beats the one from their last game
+	if profile, err := profiles.Get(playerID); err == nil {
+		ratings.Name = profile.DisplayName
+	}
+
+	matches, err := rt.store.Matches(playerID, time.Time{}, limit)
+	if err != nil {
+		return nil, false, err
+	}
+
+	games := ratings.Engineer.Games + ratings.Impostor.Games
+	wins := ratings.Engineer.Wins + ratings.Impostor.Wins
+	return &PlayerStats{
+		PlayerID: playerID,
+		Name:     ratings.Name,
+		Engineer: roleStats(ratings.Engineer),
+		Impostor: roleStats(ratings.Impostor),
+		Overall:  RoleStats{Games: games, Wins: wins, WinRate: winRate(wins, games)},
+		Matches:  matches,
+	}, true, nil
+}
+
+// queryLimit reads the limit query parameter, falling back to fallback
+// and capping it at max
+func queryLimit(r *http.Request, fallback, max int) (int, bool) {
+	raw := r.URL.Query().Get("limit")
+	if raw == "" {
+		return fallback, true
+	}
+	limit, err := strconv.Atoi(raw)
+	if err != nil || limit < 1 {
+		return 0, false
+	}
+	if limit > max {
+		limit = max
+	}
+	return limit, true
+}
+
+// ServeLeaderboard handles GET /api/leaderboard?role=&window=&limit=.
+// role is engineer (the default) or impostor; window is all (the
+// default), 24h, 7d or 30d.
+func ServeLeaderboard(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	role := query.Get("role")
+	if role == "" {
+		role = "engineer"
+	}
+	if role != "engineer" && role != "impostor" {
+		http.Error(w, "role must be engineer or impostor", http.StatusBadRequest)
+		return
+	}
+	windowName := query.Get("window")
+	if windowName == "" {
+		windowName = "all"
+	}
+	window, ok := leaderboardWindows[windowName]
+	if !ok {
+		http.Error(w, "window must be all, 24h, 7d or 30d", http.StatusBadRequest)
+		return
+	}
+	limit, ok := queryLimit(r, defaultLeaderboardSize, maxLeaderboardSize)
+	if !ok {
+		http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+		return
+	}
+
+	entries, err := hub.ratings.Leaderboard(role, window, limit)
+	if err != nil {
+		log.Printf("[LGTM] Failed to build leaderboard: %v", err)
+		http.Error(w, "failed to load ratings", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"role":    role,
+		"window":  windowName,
+		"players": entries,
+	})
+}
+
+// ServePlayerStats handles GET /api/players/{id}?limit=, limit being
+// how many recent matches to include
+func ServePlayerStats(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/players/"), "/")
+	if id == "" {
+		http.NotFound(w, r)
+		return
+	}
+	limit, ok := queryLimit(r, defaultMatchHistory, maxMatchHistory)
+	if !ok {
+		http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+		return
+	}
+
+	stats, found, err := hub.ratings.Stats(hub.identity.store, id, limit)
+	if err != nil {
+		log.Printf("[LGTM] Failed to load stats for %s: %v", id, err)
+		http.Error(w, "failed to load ratings", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "player not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
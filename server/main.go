@@ -1,30 +1,121 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 func main() {
+	config, err := LoadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("[LGTM] %v", err)
+	}
+
+	if !config.RunnerSandbox {
+		log.Printf("⚠️  [LGTM] Runner sandbox is off: submissions run as the server's user")
+	} else if err := checkSandbox(); err != nil {
+		log.Fatalf("[LGTM] %v (pass -runner-sandbox=false to run submissions unsandboxed, for development only)", err)
+	} else if err := limitProcs(config.RunnerMaxProcs); err != nil {
+		log.Fatalf("[LGTM] Failed to limit runner processes: %v", err)
+	} else if config.RunnerNamespaces {
+		if err := checkNamespaces(); err != nil {
+			// Still a different user with resource limits, but sharing the
+			// server's network; see -runner-namespaces
+			log.Printf("⚠️  [LGTM] Can't create namespaces for submissions (%v), running them without", err)
+			config.RunnerNamespaces = false
+		}
+	}
+
 	// Load tasks from file
-	if err := LoadTasks(); err != nil {
-		log.Fatalf("[LGTM] Failed to load tasks.json: %v", err)
+	if err := LoadTasks(config.Tasks); err != nil {
+		log.Fatalf("[LGTM] Failed to load %s: %v", config.Tasks, err)
+	}
+	if config.TasksReload > 0 {
+		go WatchTasks(config.Tasks, config.TasksReload.D(), nil)
 	}
 
-	hub := NewHub()
+	hub := NewHub(config)
 	go hub.Run()
 
 	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
 		ServeWs(hub, w, r)
 	})
 
+	http.HandleFunc("/api/rooms", func(w http.ResponseWriter, r *http.Request) {
+		ServeRooms(hub, w, r)
+	})
+	http.HandleFunc("/api/rooms/", func(w http.ResponseWriter, r *http.Request) {
+		ServeRooms(hub, w, r)
+	})
+
+	http.HandleFunc("/protocol.json", ServeProtocol)
+
+	http.HandleFunc("/healthz", ServeHealthz)
+	http.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		ServeReadyz(hub, w, r)
+	})
+
+	http.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		ServeMetrics(hub, w, r)
+	})
+
+	http.HandleFunc("/api/identity/", func(w http.ResponseWriter, r *http.Request) {
+		ServeIdentity(hub, w, r)
+	})
+
+	http.HandleFunc("/api/leaderboard", func(w http.ResponseWriter, r *http.Request) {
+		ServeLeaderboard(hub, w, r)
+	})
+	http.HandleFunc("/api/players/", func(w http.ResponseWriter, r *http.Request) {
+		ServePlayerStats(hub, w, r)
+	})
+
+	http.HandleFunc("/api/games/", func(w http.ResponseWriter, r *http.Request) {
+		ServeGameLog(hub, w, r)
+	})
+
 	// Serve static files for production
-	http.Handle("/", http.FileServer(http.Dir("../client/dist")))
+	http.Handle("/", http.FileServer(http.Dir(config.StaticDir)))
+
+	log.Printf("🚀 LGTM server running on %s", config.Addr)
+	log.Printf("📡 WebSocket endpoint: ws://localhost%s/ws", config.Addr)
+
+	server := &http.Server{Addr: config.Addr}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal("ListenAndServe: ", err)
+		}
+	}()
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+	log.Printf("🛑 [LGTM] Received %v, draining for up to %v (signal again to exit now)", sig, config.DrainTimeout)
+
+	go func() {
+		<-signals
+		log.Printf("[LGTM] Second signal, exiting")
+		os.Exit(1)
+	}()
 
-	log.Println("🚀 LGTM server running on :8081")
-	log.Println("📡 WebSocket endpoint: ws://localhost:8081/ws")
+	// Keep serving so running games and health checks still work
+	hub.Drain(config.DrainTimeout.D())
+	hub.CloseAll()
 
-	if err := http.ListenAndServe(":8081", nil); err != nil {
-		log.Fatal("ListenAndServe: ", err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("[LGTM] HTTP shutdown: %v", err)
 	}
+	log.Println("👋 LGTM server stopped")
 }
@@ -0,0 +1,518 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// Quick play: clients send queue-join and wait while the matchmaker groups
+// them. Once a room's worth of players with compatible language and
+// difficulty preferences are waiting, it creates a private room, seats
+// them and runs a ready check. If everyone accepts in time the game
+// starts; otherwise those who accepted go back to the queue in their old
+// place and the rest are dropped.
+
+var (
+	errAlreadyQueued = errors.New("already in the queue")
+	errNoReadyCheck  = errors.New("no ready check to accept")
+)
+
+type queueEntry struct {
+	client     *Client
+	name       string
+	language   string // "" for any
+	difficulty string // "" for any
+	joinedAt   time.Time
+}
+
+type readyCheck struct {
+	room     *Room
+	entries  []*queueEntry
+	ready    map[*Client]bool
+	deadline time.Time
+	timer    *time.Timer
+	done     bool // started or failed
+}
+
+type Matchmaker struct {
+	hub     *Hub
+	queue   []*queueEntry // oldest first
+	checks  map[*Client]*readyCheck
+	avgWait time.Duration // moving average of time to a match
+	mutex   sync.Mutex
+}
+
+func NewMatchmaker(hub *Hub) *Matchmaker {
+	return &Matchmaker{
+		hub:    hub,
+		queue:  make([]*queueEntry, 0),
+		checks: make(map[*Client]*readyCheck),
+	}
+}
+
+// matchSize is how many players a quick-play room seats
+func (m *Matchmaker) matchSize() int {
+	return DefaultRoomSettings(m.hub.config).MinPlayers
+}
+
+// queueHasTasks reports whether any task fits a language and difficulty
+func queueHasTasks(language, difficulty string) bool {
+	_, err := SelectTask(GetTasks(), queueFilter(difficulty), language, nil)
+	return err == nil
+}
+
+func queueFilter(difficulty string) TaskFilter {
+	filter := TaskFilter{}
+	if difficulty != "" {
+		filter.Difficulties = []string{difficulty}
+	}
+	return filter
+}
+
+// Len is how many clients are waiting in the queue
+func (m *Matchmaker) Len() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return len(m.queue)
+}
+
+// Join queues a client and starts ready checks for any groups that fill
+func (m *Matchmaker) Join(entry *queueEntry) error {
+	m.mutex.Lock()
+	if m.queuedLocked(entry.client.id) {
+		m.mutex.Unlock()
+		return errAlreadyQueued
+	}
+	m.queue = append(m.queue, entry)
+	m.mutex.Unlock()
+
+	m.match()
+	return nil
+}
+
+// Remove takes a client out of the queue, or fails its ready check.
+// It reports whether the client was queued or in a ready check.
+func (m *Matchmaker) Remove(client *Client) bool {
+	m.mutex.Lock()
+	if i := m.indexLocked(client); i >= 0 {
+		m.queue = append(m.queue[:i], m.queue[i+1:]...)
+		m.mutex.Unlock()
+		m.pushStatus()
+		return true
+	}
+	check := m.checks[client]
+	m.mutex.Unlock()
+
+	if check == nil {
+		return false
+	}
+	m.failReadyCheck(check, client, "A player left before the game started.", true)
+	return true
+}
+
+// Accept marks a client ready and starts the game once everyone is
+func (m *Matchmaker) Accept(client *Client) error {
+	m.mutex.Lock()
+	check := m.checks[client]
+	if check == nil {
+		m.mutex.Unlock()
+		return errNoReadyCheck
+	}
+	check.ready[client] = true
+	everyone := len(check.ready) == len(check.entries)
+	if everyone {
+		check.done = true
+		check.timer.Stop()
+		for _, e := range check.entries {
+			delete(m.checks, e.client)
+		}
+	}
+	status := check.statusLocked()
+	m.mutex.Unlock()
+
+	data, _ := json.Marshal(status)
+	for _, e := range check.entries {
+		e.client.trySend(data)
+	}
+	if !everyone {
+		return nil
+	}
+
+	if m.hub.IsDraining() {
+		m.dissolve(check, nil, "Server is restarting, no new games can start.", nil)
+		return nil
+	}
+	err := check.room.StartGame()
+	if errors.Is(err, errNotInLobby) {
+		// Already playing; there is nothing to break up
+		return nil
+	}
+	if err != nil {
+		log.Printf("[LGTM] Quick-play room %s could not start: %v", check.room.code, err)
+		m.dissolve(check, nil, "The game could not start.", nil)
+		return nil
+	}
+	log.Printf("🎮 [LGTM] Quick-play game started in room: %s", check.room.code)
+	return nil
+}
+
+// Checking reports whether room is waiting on a ready check
+func (m *Matchmaker) Checking(room *Room) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, check := range m.checks {
+		if check.room == room {
+			return true
+		}
+	}
+	return false
+}
+
+// queuedLocked reports whether a player ID is queued or in a ready check,
+// from any connection. Caller must hold m.mutex.
+func (m *Matchmaker) queuedLocked(playerID string) bool {
+	for _, e := range m.queue {
+		if e.client.id == playerID {
+			return true
+		}
+	}
+	for client := range m.checks {
+		if client.id == playerID {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Matchmaker) indexLocked(client *Client) int {
+	for i, e := range m.queue {
+		if e.client == client {
+			return i
+		}
+	}
+	return -1
+}
+
+// match forms every group it can from the queue, oldest players first
+func (m *Matchmaker) match() {
+	m.mutex.Lock()
+	groups := make([][]*queueEntry, 0)
+	for {
+		group := m.takeGroupLocked()
+		if group == nil {
+			break
+		}
+		groups = append(groups, group)
+	}
+	m.mutex.Unlock()
+
+	for _, group := range groups {
+		m.startReadyCheck(group)
+	}
+	m.pushStatus()
+}
+
+// takeGroupLocked removes and returns the first full group of compatible
+// players, or nil if there is none. An entry without a preference fits
+// any; the group takes on the preferences of the players in it.
+// Caller must hold m.mutex.
+func (m *Matchmaker) takeGroupLocked() []*queueEntry {
+	size := m.matchSize()
+	for i := range m.queue {
+		group := []int{i}
+		language, difficulty := m.queue[i].language, m.queue[i].difficulty
+		for j := i + 1; j < len(m.queue) && len(group) < size; j++ {
+			e := m.queue[j]
+			if e.language != "" && language != "" && e.language != language {
+				continue
+			}
+			if e.difficulty != "" && difficulty != "" && e.difficulty != difficulty {
+				continue
+			}
+			groupLanguage, groupDifficulty := language, difficulty
+			if groupLanguage == "" {
+				groupLanguage = e.language
+			}
+			if groupDifficulty == "" {
+				groupDifficulty = e.difficulty
+			}
+			if !queueHasTasks(groupLanguage, groupDifficulty) {
+				continue
+			}
+			language, difficulty = groupLanguage, groupDifficulty
+			group = append(group, j)
+		}
+		if len(group) < size {
+			continue
+		}
+
+		entries := make([]*queueEntry, 0, size)
+		remaining := make([]*queueEntry, 0, len(m.queue)-size)
+		taken := make(map[int]bool, size)
+		for _, j := range group {
+			taken[j] = true
+			entries = append(entries, m.queue[j])
+		}
+		for j, e := range m.queue {
+			if !taken[j] {
+				remaining = append(remaining, e)
+			}
+		}
+		m.queue = remaining
+		return entries
+	}
+	return nil
+}
+
+// startReadyCheck seats a group in a new private room and asks everyone
+// to accept
+func (m *Matchmaker) startReadyCheck(group []*queueEntry) {
+	language, difficulty := "", ""
+	for _, e := range group {
+		if language == "" {
+			language = e.language
+		}
+		if difficulty == "" {
+			difficulty = e.difficulty
+		}
+	}
+
+	roomCode := GenerateRoomCode()
+	room := m.hub.CreateRoom(roomCode)
+	settings := room.Settings()
+	settings.Language = language
+	settings.TaskFilter = queueFilter(difficulty)
+	if err := room.UpdateSettings(settings, nil); err != nil {
+		log.Printf("[LGTM] Quick-play room %s rejected settings: %v", roomCode, err)
+	}
+
+	now := time.Now()
+	check := &readyCheck{
+		room:     room,
+		entries:  group,
+		ready:    make(map[*Client]bool),
+		deadline: now.Add(m.hub.config.QueueReadyTimeout.D()),
+	}
+
+	players := make([]*Player, len(group))
+	for i, e := range group {
+		if e.client.spectating != nil {
+			e.client.spectating.RemoveSpectator(e.client)
+		}
+		players[i] = room.AddPlayer(e.client, e.name)
+	}
+
+	m.mutex.Lock()
+	for _, e := range group {
+		m.checks[e.client] = check
+		wait := now.Sub(e.joinedAt)
+		if m.avgWait == 0 {
+			m.avgWait = wait
+		} else {
+			m.avgWait += (wait - m.avgWait) / 5
+		}
+	}
+	check.timer = time.AfterFunc(m.hub.config.QueueReadyTimeout.D(), func() {
+		m.failReadyCheck(check, nil, "Not everyone accepted in time.", true)
+	})
+	status := check.statusLocked()
+	m.mutex.Unlock()
+
+	publicPlayers := room.GetPlayersPublic()
+	taskPool := room.TaskPool()
+	readyData, _ := json.Marshal(status)
+	for i, e := range group {
+		found, _ := json.Marshal(RoomJoinedMessage{
+			Type:        "match-found",
+			RoomCode:    roomCode,
+			Player:      players[i],
+			Players:     publicPlayers,
+			Settings:    room.Settings(),
+			TaskPool:    taskPool,
+			ResumeToken: m.hub.IssueResumeToken(roomCode, players[i].ID),
+		})
+		e.client.trySend(found)
+		e.client.trySend(readyData)
+	}
+
+	log.Printf("🤝 [LGTM] Matched %d players into room %s (language %q, difficulty %q)", len(group), roomCode, language, difficulty)
+}
+
+// statusLocked is the ready check as clients see it.
+// Caller must hold the matchmaker's mutex.
+func (check *readyCheck) statusLocked() ReadyCheckMessage {
+	ready := make([]string, 0, len(check.ready))
+	for _, e := range check.entries {
+		if check.ready[e.client] {
+			ready = append(ready, e.client.id)
+		}
+	}
+	return ReadyCheckMessage{
+		Type:     "ready-check",
+		RoomCode: check.room.code,
+		Deadline: check.deadline.UnixMilli(),
+		Ready:    ready,
+		Waiting:  len(check.entries) - len(ready),
+	}
+}
+
+// failReadyCheck breaks up a ready check that timed out or lost a player.
+// With requeue, players who had accepted go back to their old place in
+// the queue; gone is the player who left, if any.
+func (m *Matchmaker) failReadyCheck(check *readyCheck, gone *Client, reason string, requeue bool) {
+	m.mutex.Lock()
+	if check.done {
+		m.mutex.Unlock()
+		return
+	}
+	check.done = true
+	check.timer.Stop()
+	requeued := make(map[*Client]bool)
+	for _, e := range check.entries {
+		delete(m.checks, e.client)
+		if requeue && e.client != gone && check.ready[e.client] {
+			requeued[e.client] = true
+			m.requeueLocked(e)
+		}
+	}
+	m.mutex.Unlock()
+
+	m.dissolve(check, gone, reason, requeued)
+	if len(requeued) > 0 {
+		m.match()
+	}
+}
+
+// requeueLocked puts an entry back in joining order.
+// Caller must hold m.mutex.
+func (m *Matchmaker) requeueLocked(entry *queueEntry) {
+	i := len(m.queue)
+	for i > 0 && m.queue[i-1].joinedAt.After(entry.joinedAt) {
+		i--
+	}
+	m.queue = append(m.queue, nil)
+	copy(m.queue[i+1:], m.queue[i:])
+	m.queue[i] = entry
+}
+
+// dissolve empties and deletes a quick-play room that won't be played,
+// telling each player whether they are back in the queue. A room whose
+// game is already running is left alone.
+func (m *Matchmaker) dissolve(check *readyCheck, gone *Client, reason string, requeued map[*Client]bool) {
+	check.room.mutex.RLock()
+	gameState := check.room.gameState
+	check.room.mutex.RUnlock()
+	if gameState != StateLobby {
+		log.Printf("[LGTM] Ready check in room %s ended after its game started: %s", check.room.code, reason)
+		return
+	}
+	for _, e := range check.entries {
+		check.room.DetachPlayer(e.client)
+		if e.client == gone {
+			continue
+		}
+		data, _ := json.Marshal(ReadyCheckFailedMessage{
+			Type:     "ready-check-failed",
+			RoomCode: check.room.code,
+			Message:  reason,
+			Requeued: requeued[e.client],
+		})
+		e.client.trySend(data)
+	}
+	m.hub.DeleteRoom(check.room.code)
+	check.room.Close()
+	log.Printf("🚪 [LGTM] Ready check failed in room %s: %s", check.room.code, reason)
+}
+
+// pushStatus tells every waiting client where it is in the queue
+func (m *Matchmaker) pushStatus() {
+	m.mutex.Lock()
+	size := m.matchSize()
+	now := time.Now()
+	messages := make(map[*Client][]byte, len(m.queue))
+	for i, e := range m.queue {
+		status := QueueStatusMessage{
+			Type:       "queue-status",
+			Position:   i + 1,
+			Queued:     len(m.queue),
+			Language:   e.language,
+			Difficulty: e.difficulty,
+		}
+		// No estimate until the first match gives an average
+		if m.avgWait > 0 {
+			rounds := time.Duration(i/size + 1)
+			remaining := m.avgWait*rounds - now.Sub(e.joinedAt)
+			if remaining < 0 {
+				remaining = 0
+			}
+			seconds := int((remaining + time.Second - 1) / time.Second)
+			status.EstimatedWait = &seconds
+		}
+		messages[e.client], _ = json.Marshal(status)
+	}
+	m.mutex.Unlock()
+
+	for client, data := range messages {
+		client.trySend(data)
+	}
+}
+
+func (c *Client) handleQueueJoin(playerName, language, difficulty string) {
+	if c.hub.IsDraining() {
+		c.sendError(ErrCodeServerDraining, "Server is restarting, no new games can start!")
+		return
+	}
+	if c.room != nil {
+		c.sendError(ErrCodeInvalidState, "Leave your room before joining the queue!")
+		return
+	}
+	if language != "" {
+		if _, ok := Languages[language]; !ok {
+			c.sendError(ErrCodeBadRequest, "Unknown language!")
+			return
+		}
+	}
+	if difficulty != "" && !isTaskDifficulty(difficulty) {
+		c.sendError(ErrCodeBadRequest, "Difficulty must be easy, medium or hard!")
+		return
+	}
+	if !queueHasTasks(language, difficulty) {
+		c.sendError(ErrCodeInvalidSettings, "No tasks match that language and difficulty!")
+		return
+	}
+
+	err := c.hub.matchmaker.Join(&queueEntry{
+		client:     c,
+		name:       playerName,
+		language:   language,
+		difficulty: difficulty,
+		joinedAt:   time.Now(),
+	})
+	if err != nil {
+		c.sendError(ErrCodeInvalidState, "You're already in the queue!")
+		return
+	}
+	log.Printf("⏳ [LGTM] %s joined the quick-play queue", playerName)
+}
+
+func (c *Client) handleQueueLeave() {
+	if !c.hub.matchmaker.Remove(c) {
+		c.sendError(ErrCodeInvalidState, "You're not in the queue!")
+		return
+	}
+	data, _ := json.Marshal(NoticeMessage{
+		Type:    "queue-left",
+		Message: "You left the queue.",
+	})
+	c.trySend(data)
+}
+
+func (c *Client) handleQueueReady() {
+	if err := c.hub.matchmaker.Accept(c); err != nil {
+		c.sendError(ErrCodeInvalidState, "There's no ready check to accept!")
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// useMatchmakingTasks swaps in an easy task playable in JavaScript or
+// Python and a hard one in JavaScript only
+func useMatchmakingTasks() {
+	easy := testTask(1)
+	easy.Difficulty = "easy"
+	easy.StarterCodes = map[string]string{"python": "def sum(a, b):\n    pass"}
+	hard := testTask(2)
+	hard.Difficulty = "hard"
+
+	tasksMutex.Lock()
+	Tasks = []Task{easy, hard}
+	tasksMutex.Unlock()
+}
+
+func TestTakeGroup(t *testing.T) {
+	type pref struct{ language, difficulty string }
+	tests := []struct {
+		name  string
+		queue []pref
+		want  []int // queue indexes matched, nil for no match
+	}{
+		{"four without preferences", []pref{{}, {}, {}, {}, {}}, []int{0, 1, 2, 3}},
+		{"not enough players", []pref{{}, {}, {}}, nil},
+		{"other language skipped", []pref{{"javascript", ""}, {"python", ""}, {}, {"javascript", ""}, {}}, []int{0, 2, 3, 4}},
+		{"other difficulty skipped", []pref{{"", "easy"}, {"", "hard"}, {}, {"", "easy"}, {}}, []int{0, 2, 3, 4}},
+		{"no task for the mix", []pref{{"python", ""}, {"", "hard"}, {}, {}, {}}, []int{0, 2, 3, 4}},
+		{"first player can't be matched", []pref{{"python", "hard"}, {}, {}, {}, {}}, []int{1, 2, 3, 4}},
+		{"two languages, neither full", []pref{{"javascript", ""}, {"python", ""}, {"javascript", ""}, {"python", ""}, {"javascript", ""}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hub := newTestHub(t)
+			useMatchmakingTasks()
+			m := NewMatchmaker(hub)
+			entries := make(map[*queueEntry]int)
+			for i, p := range tt.queue {
+				entry := &queueEntry{
+					client:     newTestClient(t, hub, fmt.Sprintf("player-%d", i)).Client,
+					language:   p.language,
+					difficulty: p.difficulty,
+				}
+				entries[entry] = i
+				m.queue = append(m.queue, entry)
+			}
+
+			group := m.takeGroupLocked()
+			var got []int
+			for _, entry := range group {
+				got = append(got, entries[entry])
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("matched %v, want %v", got, tt.want)
+			}
+			if len(m.queue) != len(tt.queue)-len(got) {
+				t.Errorf("%d left in the queue, want %d", len(m.queue), len(tt.queue)-len(got))
+			}
+		})
+	}
+}
+
+// queueTestPlayers queues n clients and waits for their ready check
+func queueTestPlayers(t *testing.T, hub *Hub, n int) ([]*testClient, *Room) {
+	t.Helper()
+	clients := make([]*testClient, n)
+	for i := range clients {
+		clients[i] = newTestClient(t, hub, fmt.Sprintf("player-%d", i+1))
+		clients[i].do("queue-join", QueueJoinRequest{PlayerName: fmt.Sprintf("Player %d", i+1)})
+	}
+	var found RoomJoinedMessage
+	for _, c := range clients {
+		c.expect("match-found", &found)
+		c.expect("ready-check", nil)
+	}
+	return clients, hub.GetRoom(found.RoomCode)
+}
+
+func TestReadyCheck(t *testing.T) {
+	hub := newTestHub(t)
+	clients, room := queueTestPlayers(t, hub, 4)
+
+	// Nobody starts a quick-play game by hand, not even its host
+	room.mutex.RLock()
+	host := room.hostID
+	room.mutex.RUnlock()
+	for _, c := range clients {
+		if c.id == host {
+			c.do("start-game", nil)
+			c.expectError(ErrCodeInvalidState)
+		}
+	}
+
+	clients[0].do("queue-join", QueueJoinRequest{PlayerName: "Player 1"})
+	clients[0].expectError(ErrCodeInvalidState)
+
+	for _, c := range clients {
+		c.do("queue-ready", nil)
+	}
+	for _, c := range clients {
+		c.expect("game-started", nil)
+	}
+	if hub.matchmaker.Checking(room) {
+		t.Error("ready check still running after the game started")
+	}
+}
+
+func TestReadyCheckFails(t *testing.T) {
+	tests := []struct {
+		name     string
+		accepted int
+		fail     func(m *Matchmaker, clients []*testClient)
+		requeued int
+	}{
+		{"player leaves", 2, func(m *Matchmaker, clients []*testClient) {
+			m.Remove(clients[3].Client)
+		}, 2},
+		{"accepted player leaves", 2, func(m *Matchmaker, clients []*testClient) {
+			m.Remove(clients[0].Client)
+		}, 1},
+		{"time runs out", 3, func(m *Matchmaker, clients []*testClient) {
+			time.Sleep(m.hub.config.QueueReadyTimeout.D() + 100*time.Millisecond)
+		}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hub := newTestHub(t)
+			hub.config.QueueReadyTimeout = Duration(200 * time.Millisecond)
+			clients, room := queueTestPlayers(t, hub, 4)
+			for _, c := range clients[:tt.accepted] {
+				c.do("queue-ready", nil)
+			}
+			tt.fail(hub.matchmaker, clients)
+
+			if hub.GetRoom(room.code) != nil {
+				t.Error("room kept after the ready check failed")
+			}
+			if got := hub.matchmaker.Len(); got != tt.requeued {
+				t.Errorf("%d back in the queue, want %d", got, tt.requeued)
+			}
+			for i, c := range clients[:tt.accepted] {
+				if c.room != nil {
+					t.Errorf("player %d still seated", i+1)
+				}
+			}
+		})
+	}
+}
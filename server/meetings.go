@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Emergency meetings are rationed: none can be called until the room's
+// meeting cooldown has passed since the game started or the last meeting
+// ended, each player has a budget of meetingsPerPlayer, and ejected
+// players can't call them at all.
+
+var (
+	errMeetingEjected   = errors.New("ejected players can't call meetings")
+	errNoMeetingsLeft   = errors.New("no meetings left")
+	errMeetingNotNow    = errors.New("meetings can only be called while playing")
+	errMeetingNotPlayer = errors.New("only players can call meetings")
+)
+
+// MeetingCooldownError rejects a meeting called too early
+type MeetingCooldownError struct {
+	Remaining time.Duration
+}
+
+func (e *MeetingCooldownError) Error() string {
+	return fmt.Sprintf("next meeting available in %ds", e.Seconds())
+}
+
+// Seconds is the remaining cooldown rounded up, as shown to players
+func (e *MeetingCooldownError) Seconds() int {
+	return int((e.Remaining + time.Second - 1) / time.Second)
+}
+
+// meetingsLeftLocked is how many meetings the player may still call.
+// Caller must hold r.mutex.
+func (r *Room) meetingsLeftLocked(player *Player) int {
+	left := r.settings.MeetingsPerPlayer - r.meetingsUsed[player.ID]
+	if left < 0 {
+		return 0
+	}
+	return left
+}
+
+// meetingCooldownLocked is how long until a meeting may be called, zero
+// if one may be called now. Caller must hold r.mutex.
+func (r *Room) meetingCooldownLocked() time.Duration {
+	remaining := time.Until(r.meetingsAvailableAt)
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// meetingCooldownSecondsLocked rounds the cooldown up for clients.
+// Caller must hold r.mutex.
+func (r *Room) meetingCooldownSecondsLocked() int {
+	return (&MeetingCooldownError{Remaining: r.meetingCooldownLocked()}).Seconds()
+}
+
+// startMeetingCooldownLocked holds off meetings for the room's cooldown.
+// Caller must hold r.mutex.
+func (r *Room) startMeetingCooldownLocked() {
+	r.meetingsAvailableAt = time.Now().Add(time.Duration(r.settings.MeetingCooldown) * time.Second)
+}
+
+// checkMeetingLocked reports why the player can't call a meeting now, or
+// nil if they can. Caller must hold r.mutex.
+func (r *Room) checkMeetingLocked(player *Player) error {
+	if player == nil {
+		return errMeetingNotPlayer
+	}
+	if r.gameState != StatePlaying {
+		return errMeetingNotNow
+	}
+	if !player.IsAlive {
+		return errMeetingEjected
+	}
+	if r.meetingsLeftLocked(player) == 0 {
+		return errNoMeetingsLeft
+	}
+	if remaining := r.meetingCooldownLocked(); remaining > 0 {
+		return &MeetingCooldownError{Remaining: remaining}
+	}
+	return nil
+}
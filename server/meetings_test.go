@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCallMeeting(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(room *Room, caller *Player)
+		want  string // error code, "" if the meeting starts
+	}{
+		{"allowed", func(room *Room, caller *Player) {}, ""},
+		{"cooling down", func(room *Room, caller *Player) {
+			room.meetingsAvailableAt = time.Now().Add(time.Minute)
+		}, ErrCodeMeetingCooldown},
+		{"budget used", func(room *Room, caller *Player) {
+			room.meetingsUsed[caller.ID] = room.settings.MeetingsPerPlayer
+		}, ErrCodeNotAllowed},
+		{"someone else's budget used", func(room *Room, caller *Player) {
+			room.meetingsUsed["player-2"] = room.settings.MeetingsPerPlayer
+		}, ""},
+		{"ejected", func(room *Room, caller *Player) {
+			caller.IsAlive = false
+		}, ErrCodeNotAllowed},
+		{"game over", func(room *Room, caller *Player) {
+			room.gameState = StateEnded
+		}, ErrCodeNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			room, clients := startTestGame(t, newTestHub(t), 4)
+			caller := clients[0]
+			player := caller.player(room)
+			room.mutex.Lock()
+			room.meetingsAvailableAt = time.Now()
+			tt.setup(room, player)
+			room.mutex.Unlock()
+
+			caller.do("call-meeting", nil)
+			if tt.want != "" {
+				caller.expectError(tt.want)
+				return
+			}
+			var called MeetingCalledMessage
+			caller.expect("meeting-called", &called)
+			if called.CallerID != player.ID {
+				t.Errorf("meeting called by %s, want %s", called.CallerID, player.ID)
+			}
+			room.mutex.RLock()
+			defer room.mutex.RUnlock()
+			if room.gameState != StateVoting || room.meetingsUsed[player.ID] != 1 {
+				t.Errorf("got state %s with %d meetings used, want voting with 1", room.gameState, room.meetingsUsed[player.ID])
+			}
+		})
+	}
+}
@@ -0,0 +1,442 @@
+package main
+
+import "encoding/json"
+
+// Messages a client sends arrive as {"type": ..., "data": ...}; the
+// *Request types below are the data payloads. Messages the server sends
+// are flat objects whose type field is set where they are built.
+
+type CreateRoomRequest struct {
+	PlayerName string `json:"playerName"`
+}
+
+type JoinRoomRequest struct {
+	RoomCode   string `json:"roomCode"`
+	PlayerName string `json:"playerName"`
+	Password   string `json:"password,omitempty"`
+}
+
+type ResumeSessionRequest struct {
+	Token string `json:"token"`
+}
+
+type ReplayRequest struct {
+	GameID string `json:"gameId"`
+	Speed  int    `json:"speed,omitempty"` // 1, 4 or 16; defaults to 1
+}
+
+type ReplayControlRequest struct {
+	Seek  *int64 `json:"seek,omitempty"` // ms from the start of the game
+	Speed int    `json:"speed,omitempty"`
+}
+
+type SpectateRoomRequest struct {
+	RoomCode string `json:"roomCode"`
+	Password string `json:"password,omitempty"`
+}
+
+// UpdateSettingsRequest is decoded over the room's current settings, so
+// fields left out keep their value. A nil password keeps the current one.
+type UpdateSettingsRequest struct {
+	RoomSettings
+	Password *string `json:"password,omitempty"`
+}
+
+type CodeUpdateRequest struct {
+	Code string `json:"code"`
+}
+
+type CodeOpRequest struct {
+	Revision int    `json:"revision"`
+	Ops      []Edit `json:"ops"`
+}
+
+type CastVoteRequest struct {
+	TargetID string `json:"targetId"` // a player ID or "skip"
+}
+
+type ChatMessageRequest struct {
+	Message string `json:"message"`
+	Channel string `json:"channel,omitempty"`
+}
+
+// GhostTaskRequest submits a ghost's private copy of the code
+type GhostTaskRequest struct {
+	Code string `json:"code"`
+}
+
+type QueueJoinRequest struct {
+	PlayerName string `json:"playerName"`
+	Language   string `json:"language,omitempty"`   // empty for any
+	Difficulty string `json:"difficulty,omitempty"` // empty for any
+}
+
+type KickPlayerRequest struct {
+	PlayerID string `json:"playerId"`
+}
+
+type TransferHostRequest struct {
+	PlayerID string `json:"playerId"`
+}
+
+// EmptyRequest is the payload of messages that carry no data
+type EmptyRequest struct{}
+
+// PublicPlayer is a player as other clients see them. Role is only
+// filled in where everyone may know it: the recording and game-ended.
+type PublicPlayer struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Role      string `json:"role,omitempty"`
+	IsAlive   bool   `json:"isAlive"`
+	Color     string `json:"color"`
+	Connected bool   `json:"connected"`
+	IsHost    bool   `json:"isHost"`
+	// MeetingsLeft is how many emergency meetings the player may still
+	// call this game
+	MeetingsLeft int `json:"meetingsLeft"`
+}
+
+// PlayerRef names a player in teammates, ejections and results
+type PlayerRef struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Color string `json:"color,omitempty"`
+}
+
+type VoteCount struct {
+	Voted int `json:"voted"`
+	Total int `json:"total"`
+}
+
+type WelcomeMessage struct {
+	Type            string `json:"type"`
+	ProtocolVersion int    `json:"protocolVersion"`
+	ClientID        string `json:"clientId"` // the profile ID with an identity token
+	// Profile is who the identity token says the client is
+	Profile *PublicProfile `json:"profile,omitempty"`
+}
+
+type ErrorMessage struct {
+	Type        string `json:"type"`
+	Code        string `json:"code"`
+	Message     string `json:"message"`
+	MessageType string `json:"messageType,omitempty"` // the request that failed
+	// RetryAfterMs is set on rate_limited and meeting_cooldown errors
+	RetryAfterMs int64 `json:"retryAfterMs,omitempty"`
+}
+
+// RoomJoinedMessage is sent as room-created or room-joined
+type RoomJoinedMessage struct {
+	Type        string         `json:"type"`
+	RoomCode    string         `json:"roomCode"`
+	Player      *Player        `json:"player"`
+	Players     []PublicPlayer `json:"players"`
+	Settings    RoomSettings   `json:"settings"`
+	TaskPool    TaskPool       `json:"taskPool"`
+	ResumeToken string         `json:"resumeToken"`
+}
+
+type PlayerListMessage struct {
+	Type       string         `json:"type"`
+	Players    []PublicPlayer `json:"players"`
+	Spectators int            `json:"spectators"`
+}
+
+type SettingsUpdatedMessage struct {
+	Type     string       `json:"type"`
+	Settings RoomSettings `json:"settings"`
+	TaskPool TaskPool     `json:"taskPool"`
+}
+
+// GameStartedMessage goes to each player with their role, to spectators
+// without one, and into the recording with every role and the settings
+type GameStartedMessage struct {
+	Type       string         `json:"type"`
+	GameID     string         `json:"gameId"`
+	Role       string         `json:"role,omitempty"`
+	Task       *Task          `json:"task"`
+	Language   string         `json:"language"`
+	EditorMode string         `json:"editorMode"`
+	TimeLimit  int            `json:"timeLimit"`
+	Revision   int            `json:"revision"`
+	Settings   *RoomSettings  `json:"settings,omitempty"`
+	Players    []PublicPlayer `json:"players"`
+	Teammates  []PlayerRef    `json:"teammates,omitempty"`
+	Spectator  bool           `json:"spectator,omitempty"`
+	// MeetingCooldown is the seconds until the first meeting may be called
+	MeetingCooldown int `json:"meetingCooldown"`
+}
+
+// TimeUpdateMessage is sent as time-update or voting-time-update
+type TimeUpdateMessage struct {
+	Type          string `json:"type"`
+	TimeRemaining int    `json:"timeRemaining"`
+}
+
+type CodeAckMessage struct {
+	Type     string `json:"type"`
+	Revision int    `json:"revision"`
+}
+
+type CodeOpMessage struct {
+	Type       string `json:"type"`
+	Revision   int    `json:"revision"`
+	Ops        []Edit `json:"ops"`
+	PlayerID   string `json:"playerId"`
+	PlayerName string `json:"playerName"`
+}
+
+type CodeUpdatedMessage struct {
+	Type         string `json:"type"`
+	Code         string `json:"code"`
+	Revision     int    `json:"revision"`
+	LastEditor   string `json:"lastEditor"`
+	LastEditorID string `json:"lastEditorId"`
+}
+
+// CodeSnapshotMessage lets a late joiner rebuild the document: apply ops
+// in order on top of code to reach currentRevision
+type CodeSnapshotMessage struct {
+	Type            string       `json:"type"`
+	Revision        int          `json:"revision"`
+	Code            string       `json:"code"`
+	Ops             []RevisionOp `json:"ops"`
+	CurrentRevision int          `json:"currentRevision"`
+}
+
+type TaskResultMessage struct {
+	Type        string       `json:"type"`
+	Passed      bool         `json:"passed"`
+	Results     []TestResult `json:"results"`
+	Error       string       `json:"error"`
+	SubmittedBy string       `json:"submittedBy"`
+}
+
+// NoticeMessage carries only a human-readable message, as task-failed
+// and server-draining do
+type NoticeMessage struct {
+	Type     string `json:"type"`
+	Message  string `json:"message"`
+	Deadline int64  `json:"deadline,omitempty"` // unix ms, server-draining only
+}
+
+type ChatMessage struct {
+	Type string `json:"type"`
+	ChatRecord
+}
+
+type MeetingCalledMessage struct {
+	Type        string         `json:"type"`
+	Caller      string         `json:"caller"`
+	CallerID    string         `json:"callerId"`
+	EditHistory []EditRecord   `json:"editHistory"`
+	Players     []PublicPlayer `json:"players"`
+	VotingTime  int            `json:"votingTime"`
+}
+
+type VoteCastMessage struct {
+	Type       string    `json:"type"`
+	VotesCount VoteCount `json:"votesCount"`
+}
+
+type VotingEndedMessage struct {
+	Type               string            `json:"type"`
+	EjectedPlayer      *PlayerRef        `json:"ejectedPlayer"`
+	WasImpostor        bool              `json:"wasImpostor"`
+	ImpostorsRemaining int               `json:"impostorsRemaining"`
+	Votes              map[string]string `json:"votes"`
+}
+
+type BlameMessage struct {
+	Type  string      `json:"type"`
+	Lines []BlameLine `json:"lines"`
+}
+
+type GameResumedMessage struct {
+	Type            string         `json:"type"`
+	Players         []PublicPlayer `json:"players"`
+	TimeRemaining   int            `json:"timeRemaining"`
+	MeetingCooldown int            `json:"meetingCooldown"` // seconds until the next meeting
+}
+
+// GameEndedMessage reveals every role. Impostor is the first impostor,
+// kept for older clients.
+type GameEndedMessage struct {
+	Type      string         `json:"type"`
+	GameID    string         `json:"gameId"`
+	Winner    string         `json:"winner"`
+	Cause     string         `json:"cause"`
+	Reason    string         `json:"reason"`
+	Impostor  *PlayerRef     `json:"impostor"`
+	Impostors []PlayerRef    `json:"impostors"`
+	Players   []PublicPlayer `json:"players"`
+	Blame     []BlameLine    `json:"blame"`
+	Series    SeriesScore    `json:"series"`
+}
+
+// SessionResumedMessage captures everything a resuming client needs to
+// rebuild its view of the game
+type SessionResumedMessage struct {
+	Type                string            `json:"type"`
+	RoomCode            string            `json:"roomCode"`
+	Player              *Player           `json:"player"`
+	Role                string            `json:"role"`
+	Players             []PublicPlayer    `json:"players"`
+	GameState           GameState         `json:"gameState"`
+	Task                *Task             `json:"task"`
+	Language            string            `json:"language"`
+	EditorMode          string            `json:"editorMode"`
+	Code                string            `json:"code"`
+	Revision            int               `json:"revision"`
+	TimeRemaining       int               `json:"timeRemaining"`
+	VotingTimeRemaining int               `json:"votingTimeRemaining"`
+	MeetingCooldown     int               `json:"meetingCooldown"`
+	VotesCount          VoteCount         `json:"votesCount"`
+	MyVote              *string           `json:"myVote"`
+	ChatMessages        []ChatRecord      `json:"chatMessages"`
+	EditHistory         []EditRecord      `json:"editHistory"`
+	Teammates           []PlayerRef       `json:"teammates,omitempty"`
+	Ghost               *GhostModeMessage `json:"ghost,omitempty"` // set for ejected players
+	ResumeToken         string            `json:"resumeToken"`
+}
+
+// SpectateJoinedMessage is the role-free view of the room for a new
+// spectator; the game fields are left out in the lobby
+type SpectateJoinedMessage struct {
+	Type                string         `json:"type"`
+	RoomCode            string         `json:"roomCode"`
+	Players             []PublicPlayer `json:"players"`
+	Settings            RoomSettings   `json:"settings"`
+	GameState           GameState      `json:"gameState"`
+	TimeRemaining       int            `json:"timeRemaining"`
+	VotingTimeRemaining int            `json:"votingTimeRemaining"`
+	ChatMessages        []ChatRecord   `json:"chatMessages"`
+	Task                *Task          `json:"task,omitempty"`
+	Language            string         `json:"language,omitempty"`
+	EditorMode          string         `json:"editorMode,omitempty"`
+	Code                *string        `json:"code,omitempty"`
+	Revision            *int           `json:"revision,omitempty"`
+	EditHistory         []EditRecord   `json:"editHistory,omitempty"`
+}
+
+// GhostProgress is how many of the task's tests ghosts have solved and
+// the time that earned the engineers
+type GhostProgress struct {
+	Solved       int `json:"solved"`
+	Total        int `json:"total"`
+	BonusSeconds int `json:"bonusSeconds"`
+}
+
+// GhostModeMessage is sent to a player once they are ejected
+type GhostModeMessage struct {
+	Type         string         `json:"type"`
+	Players      []PublicPlayer `json:"players"` // with roles
+	ChatMessages []ChatRecord   `json:"chatMessages"`
+	Code         string         `json:"code"`
+	Progress     GhostProgress  `json:"progress"`
+}
+
+type GhostTaskResultMessage struct {
+	Type        string        `json:"type"`
+	Passed      bool          `json:"passed"`
+	Results     []TestResult  `json:"results"`
+	Error       string        `json:"error"`
+	NewlySolved int           `json:"newlySolved"`
+	Progress    GhostProgress `json:"progress"`
+}
+
+// GhostProgressMessage tells the room a ghost earned the engineers time
+type GhostProgressMessage struct {
+	Type       string        `json:"type"`
+	PlayerName string        `json:"playerName"`
+	Progress   GhostProgress `json:"progress"`
+}
+
+// RematchStatusMessage shows who has asked to play again
+type RematchStatusMessage struct {
+	Type    string   `json:"type"`
+	Ready   []string `json:"ready"`   // player IDs
+	Waiting int      `json:"waiting"` // connected players yet to opt in
+}
+
+// BackToLobbyMessage takes the room back to the lobby for a rematch
+type BackToLobbyMessage struct {
+	Type     string         `json:"type"`
+	RoomCode string         `json:"roomCode"`
+	Players  []PublicPlayer `json:"players"`
+	Settings RoomSettings   `json:"settings"`
+	TaskPool TaskPool       `json:"taskPool"`
+	Series   SeriesScore    `json:"series"`
+}
+
+// QueueStatusMessage tells a waiting client where it is in the
+// quick-play queue
+type QueueStatusMessage struct {
+	Type     string `json:"type"`
+	Position int    `json:"position"` // 1 is next
+	Queued   int    `json:"queued"`
+	// EstimatedWait is in seconds, left out until the server has seen a
+	// match to estimate from
+	EstimatedWait *int   `json:"estimatedWait,omitempty"`
+	Language      string `json:"language,omitempty"`
+	Difficulty    string `json:"difficulty,omitempty"`
+}
+
+// ReadyCheckMessage asks matched players to send queue-ready before the
+// deadline, and shows who already has
+type ReadyCheckMessage struct {
+	Type     string   `json:"type"`
+	RoomCode string   `json:"roomCode"`
+	Deadline int64    `json:"deadline"` // Unix ms
+	Ready    []string `json:"ready"`    // player IDs
+	Waiting  int      `json:"waiting"`
+}
+
+// ReadyCheckFailedMessage breaks up a quick-play room before its game
+type ReadyCheckFailedMessage struct {
+	Type     string `json:"type"`
+	RoomCode string `json:"roomCode"`
+	Message  string `json:"message"`
+	Requeued bool   `json:"requeued"` // back in the queue in the old place
+}
+
+// KickedMessage tells a client the host removed it from the room
+type KickedMessage struct {
+	Type     string `json:"type"`
+	RoomCode string `json:"roomCode"`
+	Message  string `json:"message"`
+}
+
+type RoomClosedMessage struct {
+	Type     string `json:"type"`
+	RoomCode string `json:"roomCode"`
+}
+
+type ReplayStartedMessage struct {
+	Type       string `json:"type"`
+	GameID     string `json:"gameId"`
+	RoomCode   string `json:"roomCode"`
+	Duration   int64  `json:"duration"` // ms
+	EventCount int    `json:"eventCount"`
+	Speed      int    `json:"speed"`
+}
+
+// ReplayEventMessage wraps one recorded server message. Seek marks events
+// replayed at once to catch up after a seek.
+type ReplayEventMessage struct {
+	Type   string          `json:"type"`
+	Offset int64           `json:"offset"`
+	Event  json.RawMessage `json:"event"`
+	Seek   bool            `json:"seek,omitempty"`
+}
+
+type ReplaySeekedMessage struct {
+	Type   string `json:"type"`
+	Offset int64  `json:"offset"`
+}
+
+type ReplayEndedMessage struct {
+	Type   string `json:"type"`
+	GameID string `json:"gameId"`
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Metrics holds the server's Prometheus metrics. Everything is written
+// by hand in the text exposition format to avoid a client library.
+type Metrics struct {
+	clientsConnected  atomic.Int64
+	messagesIn        *metricVec
+	messagesOut       *metricVec
+	messagesRejected  *metricVec
+	broadcastsDropped *metricVec
+	gamesStarted      *metricVec
+	gamesFinished     *metricVec
+	meetings          *metricVec
+	gameDuration      *metricVec
+	sendQueueDepth    *metricVec
+}
+
+var (
+	gameDurationBuckets   = []float64{30, 60, 120, 180, 300, 450, 600, 900, 1200}
+	sendQueueDepthBuckets = []float64{0, 1, 2, 4, 8, 16, 32, 64, 128, 256}
+)
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		messagesIn:        newCounterVec("lgtm_messages_in_total", "WebSocket messages received from clients, by type.", "type"),
+		messagesOut:       newCounterVec("lgtm_messages_out_total", "WebSocket messages written to clients, by type.", "type"),
+		messagesRejected:  newCounterVec("lgtm_messages_rejected_total", "Client messages rejected by rate or size limits, by type and reason.", "type", "reason"),
+		broadcastsDropped: newCounterVec("lgtm_broadcasts_dropped_total", "Room broadcasts dropped because a client's send queue was full.", "audience"),
+		gamesStarted:      newCounterVec("lgtm_games_started_total", "Games started."),
+		gamesFinished:     newCounterVec("lgtm_games_finished_total", "Games finished, by winner and reason.", "winner", "reason"),
+		meetings:          newCounterVec("lgtm_meetings_total", "Emergency meetings called."),
+		gameDuration:      newHistogramVec("lgtm_game_duration_seconds", "Time from game start to game end.", gameDurationBuckets),
+		sendQueueDepth:    newHistogramVec("lgtm_send_queue_depth", "Messages waiting in a client's send queue when one is written.", sendQueueDepthBuckets),
+	}
+}
+
+// MessageIn counts a received message. Types outside the protocol are
+// counted as "unknown" to bound the label.
+func (m *Metrics) MessageIn(messageType string) {
+	if _, ok := clientMessages[messageType]; !ok {
+		messageType = "unknown"
+	}
+	m.messagesIn.Add(1, messageType)
+}
+
+// MessageOut counts a message written to a client and the queue behind it
+func (m *Metrics) MessageOut(data []byte, queued int) {
+	var header struct {
+		Type string `json:"type"`
+	}
+	json.Unmarshal(data, &header)
+	m.messagesOut.Add(1, header.Type)
+	m.sendQueueDepth.Observe(float64(queued))
+}
+
+// ServeMetrics handles GET /metrics
+func ServeMetrics(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	m := hub.metrics
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	out := bufio.NewWriter(w)
+	defer out.Flush()
+
+	// Room and client gauges are read at scrape time
+	roomsByState := map[GameState]int{StateLobby: 0, StatePlaying: 0, StateVoting: 0, StateEnded: 0}
+	for _, room := range hub.Rooms() {
+		room.mutex.RLock()
+		roomsByState[room.gameState]++
+		room.mutex.RUnlock()
+	}
+	fmt.Fprintf(out, "# HELP lgtm_rooms Active rooms, by game state.\n# TYPE lgtm_rooms gauge\n")
+	for _, state := range []GameState{StateLobby, StatePlaying, StateVoting, StateEnded} {
+		fmt.Fprintf(out, "lgtm_rooms{state=%q} %d\n", string(state), roomsByState[state])
+	}
+	fmt.Fprintf(out, "# HELP lgtm_clients_connected Open WebSocket connections.\n# TYPE lgtm_clients_connected gauge\n")
+	fmt.Fprintf(out, "lgtm_clients_connected %d\n", m.clientsConnected.Load())
+	fmt.Fprintf(out, "# HELP lgtm_queue_players Clients waiting in the quick-play queue.\n# TYPE lgtm_queue_players gauge\n")
+	fmt.Fprintf(out, "lgtm_queue_players %d\n", hub.matchmaker.Len())
+
+	for _, vec := range []*metricVec{
+		m.messagesIn, m.messagesOut, m.messagesRejected, m.broadcastsDropped,
+		m.gamesStarted, m.gamesFinished, m.meetings,
+		m.gameDuration, m.sendQueueDepth,
+	} {
+		vec.write(out)
+	}
+}
+
+// metricVec is a counter or histogram with a fixed set of label names
+type metricVec struct {
+	name       string
+	help       string
+	labelNames []string
+	buckets    []float64 // nil for counters
+	series     map[string]*metricSeries
+	mutex      sync.Mutex
+}
+
+type metricSeries struct {
+	labelValues []string
+	value       float64  // counter value, or histogram sum
+	count       uint64   // histogram observations
+	counts      []uint64 // per bucket, not cumulative
+}
+
+func newCounterVec(name, help string, labelNames ...string) *metricVec {
+	return &metricVec{name: name, help: help, labelNames: labelNames, series: make(map[string]*metricSeries)}
+}
+
+func newHistogramVec(name, help string, buckets []float64, labelNames ...string) *metricVec {
+	return &metricVec{name: name, help: help, labelNames: labelNames, buckets: buckets, series: make(map[string]*metricSeries)}
+}
+
+func (v *metricVec) seriesLocked(labelValues []string) *metricSeries {
+	key := strings.Join(labelValues, "\xff")
+	s := v.series[key]
+	if s == nil {
+		s = &metricSeries{labelValues: labelValues}
+		if v.buckets != nil {
+			s.counts = make([]uint64, len(v.buckets))
+		}
+		v.series[key] = s
+	}
+	return s
+}
+
+func (v *metricVec) Add(delta float64, labelValues ...string) {
+	v.mutex.Lock()
+	v.seriesLocked(labelValues).value += delta
+	v.mutex.Unlock()
+}
+
+func (v *metricVec) Observe(value float64, labelValues ...string) {
+	v.mutex.Lock()
+	s := v.seriesLocked(labelValues)
+	s.value += value
+	s.count++
+	for i, bound := range v.buckets {
+		if value <= bound {
+			s.counts[i]++
+			break
+		}
+	}
+	v.mutex.Unlock()
+}
+
+func (v *metricVec) write(out *bufio.Writer) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	kind := "counter"
+	if v.buckets != nil {
+		kind = "histogram"
+	}
+	fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n", v.name, v.help, v.name, kind)
+
+	// Unlabelled counters report 0 before their first increment
+	if len(v.series) == 0 && len(v.labelNames) == 0 {
+		v.seriesLocked(nil)
+	}
+
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := v.series[key]
+		labels := formatLabels(v.labelNames, s.labelValues)
+		if v.buckets == nil {
+			fmt.Fprintf(out, "%s%s %s\n", v.name, wrapLabels(labels), formatFloat(s.value))
+			continue
+		}
+		cumulative := uint64(0)
+		for i, bound := range v.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(out, "%s_bucket%s %d\n", v.name, wrapLabels(labels, `le="`+formatFloat(bound)+`"`), cumulative)
+		}
+		fmt.Fprintf(out, "%s_bucket%s %d\n", v.name, wrapLabels(labels, `le="+Inf"`), s.count)
+		fmt.Fprintf(out, "%s_sum%s %s\n", v.name, wrapLabels(labels), formatFloat(s.value))
+		fmt.Fprintf(out, "%s_count%s %d\n", v.name, wrapLabels(labels), s.count)
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names, values []string) []string {
+	labels := make([]string, 0, len(names))
+	for i, name := range names {
+		labels = append(labels, name+`="`+labelEscaper.Replace(values[i])+`"`)
+	}
+	return labels
+}
+
+func wrapLabels(labels []string, extra ...string) string {
+	all := append(append([]string{}, labels...), extra...)
+	if len(all) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(all, ",") + "}"
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"unicode/utf16"
+)
+
+// Operational transform for the shared editor, modelled on ot.js.
+// An operation walks the whole document as a sequence of retain, insert
+// and delete components. Offsets and lengths are UTF-16 code units so
+// they line up with JavaScript string indexing on the client.
+
+var (
+	errOpBaseLength = errors.New("operation base length does not match document")
+	errOpCompose    = errors.New("operations cannot be composed")
+	errOpTransform  = errors.New("operations were not made against the same document")
+)
+
+type opComponent struct {
+	retain int
+	insert []uint16
+	delete int
+}
+
+func (c opComponent) isRetain() bool { return c.retain > 0 }
+func (c opComponent) isInsert() bool { return len(c.insert) > 0 }
+func (c opComponent) isDelete() bool { return c.delete > 0 }
+func (c opComponent) isEmpty() bool  { return !c.isRetain() && !c.isInsert() && !c.isDelete() }
+
+// opIterator hands out components one at a time, then empty ones
+type opIterator struct {
+	components []opComponent
+	i          int
+}
+
+func (it *opIterator) next() opComponent {
+	if it.i < len(it.components) {
+		c := it.components[it.i]
+		it.i++
+		return c
+	}
+	return opComponent{}
+}
+
+type TextOperation struct {
+	components []opComponent
+	baseLen    int
+	targetLen  int
+}
+
+// Edit is the wire form of an operation: positional edits applied one
+// after another
+type Edit struct {
+	Type   string `json:"type"` // "insert" or "delete"
+	Pos    int    `json:"pos"`
+	Text   string `json:"text,omitempty"`
+	Length int    `json:"length,omitempty"`
+}
+
+func u16(s string) []uint16 {
+	return utf16.Encode([]rune(s))
+}
+
+func (o *TextOperation) Retain(n int) *TextOperation {
+	if n <= 0 {
+		return o
+	}
+	o.baseLen += n
+	o.targetLen += n
+	if last := len(o.components) - 1; last >= 0 && o.components[last].isRetain() {
+		o.components[last].retain += n
+	} else {
+		o.components = append(o.components, opComponent{retain: n})
+	}
+	return o
+}
+
+func (o *TextOperation) Insert(s []uint16) *TextOperation {
+	if len(s) == 0 {
+		return o
+	}
+	o.targetLen += len(s)
+	last := len(o.components) - 1
+	switch {
+	case last >= 0 && o.components[last].isInsert():
+		o.components[last].insert = append(append([]uint16{}, o.components[last].insert...), s...)
+	case last >= 0 && o.components[last].isDelete():
+		// Keep inserts before deletes so equal operations look the same
+		if last >= 1 && o.components[last-1].isInsert() {
+			o.components[last-1].insert = append(append([]uint16{}, o.components[last-1].insert...), s...)
+		} else {
+			del := o.components[last]
+			o.components[last] = opComponent{insert: s}
+			o.components = append(o.components, del)
+		}
+	default:
+		o.components = append(o.components, opComponent{insert: s})
+	}
+	return o
+}
+
+func (o *TextOperation) Delete(n int) *TextOperation {
+	if n <= 0 {
+		return o
+	}
+	o.baseLen += n
+	if last := len(o.components) - 1; last >= 0 && o.components[last].isDelete() {
+		o.components[last].delete += n
+	} else {
+		o.components = append(o.components, opComponent{delete: n})
+	}
+	return o
+}
+
+// IsNoop reports whether the operation leaves the document unchanged
+func (o *TextOperation) IsNoop() bool {
+	return len(o.components) == 0 || (len(o.components) == 1 && o.components[0].isRetain())
+}
+
+func (o *TextOperation) Apply(doc string) (string, error) {
+	src := u16(doc)
+	if len(src) != o.baseLen {
+		return "", errOpBaseLength
+	}
+
+	out := make([]uint16, 0, o.targetLen)
+	pos := 0
+	for _, c := range o.components {
+		switch {
+		case c.isRetain():
+			out = append(out, src[pos:pos+c.retain]...)
+			pos += c.retain
+		case c.isInsert():
+			out = append(out, c.insert...)
+		case c.isDelete():
+			pos += c.delete
+		}
+	}
+	return string(utf16.Decode(out)), nil
+}
+
+// Edits converts the operation to sequential positional edits
+func (o *TextOperation) Edits() []Edit {
+	edits := make([]Edit, 0)
+	pos := 0
+	for _, c := range o.components {
+		switch {
+		case c.isRetain():
+			pos += c.retain
+		case c.isInsert():
+			edits = append(edits, Edit{Type: "insert", Pos: pos, Text: string(utf16.Decode(c.insert))})
+			pos += len(c.insert)
+		case c.isDelete():
+			edits = append(edits, Edit{Type: "delete", Pos: pos, Length: c.delete})
+		}
+	}
+	return edits
+}
+
+// OperationFromEdits builds one operation from sequential positional
+// edits made against a document of docLen code units
+func OperationFromEdits(docLen int, edits []Edit) (*TextOperation, error) {
+	op := (&TextOperation{}).Retain(docLen)
+	length := docLen
+
+	for _, e := range edits {
+		step := &TextOperation{}
+		switch e.Type {
+		case "insert":
+			text := u16(e.Text)
+			if e.Pos < 0 || e.Pos > length || len(text) == 0 {
+				return nil, fmt.Errorf("insert at %d is out of range", e.Pos)
+			}
+			step.Retain(e.Pos).Insert(text).Retain(length - e.Pos)
+			length += len(text)
+		case "delete":
+			if e.Pos < 0 || e.Length <= 0 || e.Pos+e.Length > length {
+				return nil, fmt.Errorf("delete of %d at %d is out of range", e.Length, e.Pos)
+			}
+			step.Retain(e.Pos).Delete(e.Length).Retain(length - e.Pos - e.Length)
+			length -= e.Length
+		default:
+			return nil, fmt.Errorf("unknown edit type %q", e.Type)
+		}
+
+		composed, err := Compose(op, step)
+		if err != nil {
+			return nil, err
+		}
+		op = composed
+	}
+	return op, nil
+}
+
+// OperationFromDiff builds an operation that turns oldDoc into newDoc by
+// replacing everything between their common prefix and suffix
+func OperationFromDiff(oldDoc, newDoc string) *TextOperation {
+	a, b := u16(oldDoc), u16(newDoc)
+
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	op := &TextOperation{}
+	op.Retain(prefix)
+	op.Delete(len(a) - prefix - suffix)
+	op.Insert(b[prefix : len(b)-suffix])
+	op.Retain(suffix)
+	return op
+}
+
+// Compose merges a and b, where b applies to the result of a, into a
+// single operation with the same effect
+func Compose(a, b *TextOperation) (*TextOperation, error) {
+	if a.targetLen != b.baseLen {
+		return nil, errOpCompose
+	}
+
+	out := &TextOperation{}
+	ia, ib := &opIterator{components: a.components}, &opIterator{components: b.components}
+	ca, cb := ia.next(), ib.next()
+
+	for !ca.isEmpty() || !cb.isEmpty() {
+		if ca.isDelete() {
+			out.Delete(ca.delete)
+			ca = ia.next()
+			continue
+		}
+		if cb.isInsert() {
+			out.Insert(cb.insert)
+			cb = ib.next()
+			continue
+		}
+		if ca.isEmpty() || cb.isEmpty() {
+			return nil, errOpCompose
+		}
+
+		switch {
+		case ca.isRetain() && cb.isRetain():
+			switch {
+			case ca.retain > cb.retain:
+				out.Retain(cb.retain)
+				ca.retain -= cb.retain
+				cb = ib.next()
+			case ca.retain == cb.retain:
+				out.Retain(ca.retain)
+				ca, cb = ia.next(), ib.next()
+			default:
+				out.Retain(ca.retain)
+				cb.retain -= ca.retain
+				ca = ia.next()
+			}
+		case ca.isInsert() && cb.isDelete():
+			switch {
+			case len(ca.insert) > cb.delete:
+				ca.insert = ca.insert[cb.delete:]
+				cb = ib.next()
+			case len(ca.insert) == cb.delete:
+				ca, cb = ia.next(), ib.next()
+			default:
+				cb.delete -= len(ca.insert)
+				ca = ia.next()
+			}
+		case ca.isInsert() && cb.isRetain():
+			switch {
+			case len(ca.insert) > cb.retain:
+				out.Insert(ca.insert[:cb.retain])
+				ca.insert = ca.insert[cb.retain:]
+				cb = ib.next()
+			case len(ca.insert) == cb.retain:
+				out.Insert(ca.insert)
+				ca, cb = ia.next(), ib.next()
+			default:
+				out.Insert(ca.insert)
+				cb.retain -= len(ca.insert)
+				ca = ia.next()
+			}
+		case ca.isRetain() && cb.isDelete():
+			switch {
+			case ca.retain > cb.delete:
+				out.Delete(cb.delete)
+				ca.retain -= cb.delete
+				cb = ib.next()
+			case ca.retain == cb.delete:
+				out.Delete(cb.delete)
+				ca, cb = ia.next(), ib.next()
+			default:
+				out.Delete(ca.retain)
+				cb.delete -= ca.retain
+				ca = ia.next()
+			}
+		default:
+			return nil, errOpCompose
+		}
+	}
+	return out, nil
+}
+
+// Transform takes concurrent operations a and b made against the same
+// document and returns a' and b' such that apply(apply(doc, a), b') ==
+// apply(apply(doc, b), a'). Inserts from a win ties.
+func Transform(a, b *TextOperation) (*TextOperation, *TextOperation, error) {
+	if a.baseLen != b.baseLen {
+		return nil, nil, errOpTransform
+	}
+
+	aPrime, bPrime := &TextOperation{}, &TextOperation{}
+	ia, ib := &opIterator{components: a.components}, &opIterator{components: b.components}
+	ca, cb := ia.next(), ib.next()
+
+	for !ca.isEmpty() || !cb.isEmpty() {
+		if ca.isInsert() {
+			aPrime.Insert(ca.insert)
+			bPrime.Retain(len(ca.insert))
+			ca = ia.next()
+			continue
+		}
+		if cb.isInsert() {
+			aPrime.Retain(len(cb.insert))
+			bPrime.Insert(cb.insert)
+			cb = ib.next()
+			continue
+		}
+		if ca.isEmpty() || cb.isEmpty() {
+			return nil, nil, errOpTransform
+		}
+
+		switch {
+		case ca.isRetain() && cb.isRetain():
+			n := min(ca.retain, cb.retain)
+			aPrime.Retain(n)
+			bPrime.Retain(n)
+			ca.retain -= n
+			cb.retain -= n
+		case ca.isDelete() && cb.isDelete():
+			// Both deleted the same text; nothing left to do for it
+			n := min(ca.delete, cb.delete)
+			ca.delete -= n
+			cb.delete -= n
+		case ca.isDelete() && cb.isRetain():
+			n := min(ca.delete, cb.retain)
+			aPrime.Delete(n)
+			ca.delete -= n
+			cb.retain -= n
+		case ca.isRetain() && cb.isDelete():
+			n := min(ca.retain, cb.delete)
+			bPrime.Delete(n)
+			ca.retain -= n
+			cb.delete -= n
+		default:
+			return nil, nil, errOpTransform
+		}
+
+		if ca.isEmpty() {
+			ca = ia.next()
+		}
+		if cb.isEmpty() {
+			cb = ib.next()
+		}
+	}
+	return aPrime, bPrime, nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTransformConverges(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		a, b []Edit
+		want string
+	}{
+		{
+			name: "inserts at different places",
+			doc:  "hello world",
+			a:    []Edit{{Type: "insert", Pos: 5, Text: ","}},
+			b:    []Edit{{Type: "insert", Pos: 11, Text: "!"}},
+			want: "hello, world!",
+		},
+		{
+			name: "inserts at the same place, a wins the tie",
+			doc:  "ab",
+			a:    []Edit{{Type: "insert", Pos: 1, Text: "X"}},
+			b:    []Edit{{Type: "insert", Pos: 1, Text: "Y"}},
+			want: "aXYb",
+		},
+		{
+			name: "inserts at the start of an empty document",
+			doc:  "",
+			a:    []Edit{{Type: "insert", Pos: 0, Text: "foo"}},
+			b:    []Edit{{Type: "insert", Pos: 0, Text: "bar"}},
+			want: "foobar",
+		},
+		{
+			name: "insert inside a deleted range",
+			doc:  "abcdef",
+			a:    []Edit{{Type: "insert", Pos: 3, Text: "X"}},
+			b:    []Edit{{Type: "delete", Pos: 1, Length: 4}},
+			want: "aXf",
+		},
+		{
+			name: "overlapping deletes",
+			doc:  "abcdef",
+			a:    []Edit{{Type: "delete", Pos: 1, Length: 3}},
+			b:    []Edit{{Type: "delete", Pos: 2, Length: 3}},
+			want: "af",
+		},
+		{
+			name: "the same delete on both sides",
+			doc:  "abcdef",
+			a:    []Edit{{Type: "delete", Pos: 2, Length: 2}},
+			b:    []Edit{{Type: "delete", Pos: 2, Length: 2}},
+			want: "abef",
+		},
+		{
+			name: "replace against an insert",
+			doc:  "let x = 1",
+			a:    []Edit{{Type: "delete", Pos: 8, Length: 1}, {Type: "insert", Pos: 8, Text: "42"}},
+			b:    []Edit{{Type: "insert", Pos: 0, Text: "// answer\n"}},
+			want: "// answer\nlet x = 42",
+		},
+		{
+			name: "insert after a surrogate pair",
+			doc:  "a😀b",
+			a:    []Edit{{Type: "insert", Pos: 3, Text: "X"}},
+			b:    []Edit{{Type: "insert", Pos: 1, Text: "🎉"}},
+			want: "a🎉😀Xb",
+		},
+		{
+			name: "delete a surrogate pair against an insert after it",
+			doc:  "a😀b",
+			a:    []Edit{{Type: "delete", Pos: 1, Length: 2}},
+			b:    []Edit{{Type: "insert", Pos: 3, Text: "🎉"}},
+			want: "a🎉b",
+		},
+		{
+			name: "surrogate pairs inserted at the same place",
+			doc:  "😀",
+			a:    []Edit{{Type: "insert", Pos: 2, Text: "🎉"}},
+			b:    []Edit{{Type: "insert", Pos: 2, Text: "🚀"}},
+			want: "😀🎉🚀",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			docLen := len(u16(tt.doc))
+			a, err := OperationFromEdits(docLen, tt.a)
+			if err != nil {
+				t.Fatalf("a: %v", err)
+			}
+			b, err := OperationFromEdits(docLen, tt.b)
+			if err != nil {
+				t.Fatalf("b: %v", err)
+			}
+			aPrime, bPrime, err := Transform(a, b)
+			if err != nil {
+				t.Fatalf("Transform: %v", err)
+			}
+
+			left := mustApply(t, mustApply(t, tt.doc, a), bPrime)
+			right := mustApply(t, mustApply(t, tt.doc, b), aPrime)
+			if left != right {
+				t.Fatalf("diverged: apply(apply(doc, a), b') = %q, apply(apply(doc, b), a') = %q", left, right)
+			}
+			if left != tt.want {
+				t.Errorf("got %q, want %q", left, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransformRejectsDifferentDocuments(t *testing.T) {
+	a := (&TextOperation{}).Retain(3).Insert(u16("x"))
+	b := (&TextOperation{}).Retain(4)
+	if _, _, err := Transform(a, b); !errors.Is(err, errOpTransform) {
+		t.Errorf("got %v, want %v", err, errOpTransform)
+	}
+}
+
+func mustApply(t *testing.T, doc string, op *TextOperation) string {
+	t.Helper()
+	out, err := op.Apply(doc)
+	if err != nil {
+		t.Fatalf("Apply(%q): %v", doc, err)
+	}
+	return out
+}
+
+func TestIsNoop(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		edits []Edit
+		want  bool
+	}{
+		{"no edits", "abc", nil, true},
+		{"insert taken back", "abc", []Edit{{Type: "insert", Pos: 1, Text: "x"}, {Type: "delete", Pos: 1, Length: 1}}, true},
+		{"insert", "abc", []Edit{{Type: "insert", Pos: 1, Text: "x"}}, false},
+		{"delete", "abc", []Edit{{Type: "delete", Pos: 0, Length: 1}}, false},
+		{"empty document", "", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			op, err := OperationFromEdits(len(u16(tt.doc)), tt.edits)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := op.IsNoop(); got != tt.want {
+				t.Errorf("IsNoop() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if !OperationFromDiff("same", "same").IsNoop() {
+		t.Error("diff of equal documents isn't a no-op")
+	}
+}
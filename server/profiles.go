@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+var (
+	errProfileNotFound = errors.New("profile not found")
+	errUsernameTaken   = errors.New("username is taken")
+)
+
+// Profile is a player identity. Guests only have a display name;
+// registered players also have a username and password to log in with
+// from another device.
+type Profile struct {
+	ID           string `json:"id"`
+	DisplayName  string `json:"displayName"`
+	Username     string `json:"username,omitempty"` // lowercase, registered only
+	PasswordSalt []byte `json:"passwordSalt,omitempty"`
+	PasswordHash []byte `json:"passwordHash,omitempty"`
+	CreatedAt    int64  `json:"createdAt"` // Unix ms
+}
+
+func (p *Profile) Guest() bool {
+	return p.Username == ""
+}
+
+// PublicProfile is a profile without its credentials
+type PublicProfile struct {
+	ID          string `json:"id"`
+	DisplayName string `json:"displayName"`
+	Username    string `json:"username,omitempty"`
+	Guest       bool   `json:"guest"`
+	CreatedAt   int64  `json:"createdAt"`
+}
+
+func (p *Profile) Public() PublicProfile {
+	return PublicProfile{
+		ID:          p.ID,
+		DisplayName: p.DisplayName,
+		Username:    p.Username,
+		Guest:       p.Guest(),
+		CreatedAt:   p.CreatedAt,
+	}
+}
+
+// ProfileStore keeps profiles. FileProfileStore is the default; anything
+// else that satisfies this, such as a database, can be passed to
+// NewIdentity instead. Implementations return copies, so callers may
+// change what they get back and Put it.
+type ProfileStore interface {
+	Get(id string) (*Profile, error)
+	GetByUsername(username string) (*Profile, error)
+	// Put creates or replaces a profile, failing with errUsernameTaken if
+	// another profile has its username
+	Put(profile *Profile) error
+}
+
+// FileProfileStore keeps every profile in memory and appends each change
+// to a file as one JSON line, so adding a guest doesn't rewrite everyone.
+// Later lines replace earlier ones for the same ID; once most lines are
+// stale the file is compacted.
+type FileProfileStore struct {
+	path       string
+	profiles   map[string]*Profile
+	byUsername map[string]*Profile
+	lines      int // lines in the file, stale ones included
+	mutex      sync.RWMutex
+}
+
+// NewFileProfileStore loads the profiles in path; a missing file is an
+// empty store
+func NewFileProfileStore(path string) (*FileProfileStore, error) {
+	s := &FileProfileStore{
+		path:       path,
+		profiles:   make(map[string]*Profile),
+		byUsername: make(map[string]*Profile),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := makePrivate(path); err != nil {
+		return nil, err
+	}
+	partial := false
+	lines := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+	for i, line := range lines {
+		if len(line) == 0 {
+			continue
+		}
+		var p Profile
+		if err := json.Unmarshal(line, &p); err != nil {
+			// A crash mid-append can only cut off the last line
+			if i == len(lines)-1 {
+				log.Printf("[LGTM] Ignoring a partly written last line in %s", path)
+				partial = true
+				break
+			}
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		s.putLocked(&p)
+		s.lines++
+	}
+	// Compacting also drops a partial line before anything is appended
+	if partial || s.staleLocked() {
+		if err := s.compactLocked(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *FileProfileStore) Get(id string) (*Profile, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	p, ok := s.profiles[id]
+	if !ok {
+		return nil, errProfileNotFound
+	}
+	copied := *p
+	return &copied, nil
+}
+
+func (s *FileProfileStore) GetByUsername(username string) (*Profile, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	p, ok := s.byUsername[username]
+	if !ok {
+		return nil, errProfileNotFound
+	}
+	copied := *p
+	return &copied, nil
+}
+
+func (s *FileProfileStore) Put(profile *Profile) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if profile.Username != "" {
+		if other, ok := s.byUsername[profile.Username]; ok && other.ID != profile.ID {
+			return errUsernameTaken
+		}
+	}
+
+	copied := *profile
+	if err := s.appendLocked(&copied); err != nil {
+		return err
+	}
+	s.putLocked(&copied)
+	if s.staleLocked() {
+		return s.compactLocked()
+	}
+	return nil
+}
+
+// putLocked indexes a profile. Caller must hold s.mutex.
+func (s *FileProfileStore) putLocked(p *Profile) {
+	if old, ok := s.profiles[p.ID]; ok && old.Username != "" {
+		delete(s.byUsername, old.Username)
+	}
+	s.profiles[p.ID] = p
+	if p.Username != "" {
+		s.byUsername[p.Username] = p
+	}
+}
+
+// staleLocked reports whether the file has grown to over twice the
+// live profiles. Caller must hold s.mutex.
+func (s *FileProfileStore) staleLocked() bool {
+	return s.lines > 2*len(s.profiles)+100
+}
+
+// appendLocked writes one profile to the end of the file.
+// Caller must hold s.mutex.
+func (s *FileProfileStore) appendLocked(p *Profile) error {
+	line, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	s.lines++
+	return f.Close()
+}
+
+// compactLocked rewrites the file with one line per profile, through a
+// temporary file so a crash can't leave it half written.
+// Caller must hold s.mutex.
+func (s *FileProfileStore) compactLocked() error {
+	profiles := make([]*Profile, 0, len(s.profiles))
+	for _, p := range s.profiles {
+		profiles = append(profiles, p)
+	}
+	sort.Slice(profiles, func(i, j int) bool {
+		return profiles[i].CreatedAt < profiles[j].CreatedAt
+	})
+	var data bytes.Buffer
+	for _, p := range profiles {
+		line, err := json.Marshal(p)
+		if err != nil {
+			return err
+		}
+		data.Write(line)
+		data.WriteByte('\n')
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data.Bytes(), 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	s.lines = len(profiles)
+	return nil
+}
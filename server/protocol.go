@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ProtocolVersion is the websocket protocol this server speaks. Clients
+// ask for it with the Sec-WebSocket-Protocol header "lgtm.v1"; clients
+// that send no subprotocol get the current version.
+const ProtocolVersion = 1
+
+const subprotocolPrefix = "lgtm.v"
+
+// supportedProtocolVersions lists every version the server still accepts
+var supportedProtocolVersions = []int{1}
+
+// Error codes sent in error replies
+const (
+	ErrCodeBadRequest       = "bad_request"
+	ErrCodeUnknownType      = "unknown_type"
+	ErrCodeNotAllowed       = "not_allowed"
+	ErrCodeInvalidState     = "invalid_state"
+	ErrCodeNotFound         = "not_found"
+	ErrCodeRoomFull         = "room_full"
+	ErrCodePasswordRequired = "password_required"
+	ErrCodeWrongPassword    = "wrong_password"
+	ErrCodeInvalidSettings  = "invalid_settings"
+	ErrCodeInvalidEdit      = "invalid_edit"
+	ErrCodeSessionExpired   = "session_expired"
+	ErrCodeServerDraining   = "server_draining"
+	ErrCodeUnavailable      = "unavailable"
+	ErrCodeRateLimited      = "rate_limited"
+	ErrCodeTooLarge         = "too_large"
+	ErrCodeMeetingCooldown  = "meeting_cooldown"
+)
+
+var errorCodes = map[string]string{
+	ErrCodeBadRequest:       "The message or its data could not be decoded, or a field has an invalid value.",
+	ErrCodeUnknownType:      "The server does not know this message type.",
+	ErrCodeNotAllowed:       "The sender may not do this, e.g. a non-host changing settings.",
+	ErrCodeInvalidState:     "The room is not in a state that allows this, e.g. joining a game in progress.",
+	ErrCodeNotFound:         "The room, player or recording does not exist.",
+	ErrCodeRoomFull:         "The room has no free seat.",
+	ErrCodePasswordRequired: "The room needs a password and none was given.",
+	ErrCodeWrongPassword:    "The room password is wrong.",
+	ErrCodeInvalidSettings:  "The requested room settings were rejected.",
+	ErrCodeInvalidEdit:      "A code operation does not apply to the document.",
+	ErrCodeSessionExpired:   "The resume token is invalid or its seat is gone.",
+	ErrCodeServerDraining:   "The server is shutting down and accepts no new rooms or games.",
+	ErrCodeUnavailable:      "The server could not do this right now; try again.",
+	ErrCodeRateLimited:      "The client sent too many messages; retryAfterMs says when to try again. Clients that keep flooding are disconnected.",
+	ErrCodeTooLarge:         "A chat message or the code is over the server's size limit.",
+	ErrCodeMeetingCooldown:  "No meeting can be called yet; retryAfterMs says when one can.",
+}
+
+// clientMessages maps every message type a client may send to its data
+var clientMessages = map[string]interface{}{
+	"create-room":     CreateRoomRequest{},
+	"join-room":       JoinRoomRequest{},
+	"resume-session":  ResumeSessionRequest{},
+	"replay":          ReplayRequest{},
+	"replay-control":  ReplayControlRequest{},
+	"replay-stop":     EmptyRequest{},
+	"spectate-room":   SpectateRoomRequest{},
+	"queue-join":      QueueJoinRequest{},
+	"queue-leave":     EmptyRequest{},
+	"queue-ready":     EmptyRequest{},
+	"start-game":      EmptyRequest{},
+	"update-settings": UpdateSettingsRequest{},
+	"kick-player":     KickPlayerRequest{},
+	"transfer-host":   TransferHostRequest{},
+	"code-update":     CodeUpdateRequest{},
+	"code-op":         CodeOpRequest{},
+	"code-sync":       EmptyRequest{},
+	"call-meeting":    EmptyRequest{},
+	"cast-vote":       CastVoteRequest{},
+	"blame":           EmptyRequest{},
+	"chat-message":    ChatMessageRequest{},
+	"submit-task":     EmptyRequest{},
+	"ghost-task":      GhostTaskRequest{},
+	"play-again":      EmptyRequest{},
+}
+
+// serverMessages maps every message type the server sends to its shape
+var serverMessages = map[string]interface{}{
+	"welcome":            WelcomeMessage{},
+	"error":              ErrorMessage{},
+	"room-created":       RoomJoinedMessage{},
+	"room-joined":        RoomJoinedMessage{},
+	"player-list":        PlayerListMessage{},
+	"settings-updated":   SettingsUpdatedMessage{},
+	"game-started":       GameStartedMessage{},
+	"time-update":        TimeUpdateMessage{},
+	"code-ack":           CodeAckMessage{},
+	"code-op":            CodeOpMessage{},
+	"code-updated":       CodeUpdatedMessage{},
+	"code-snapshot":      CodeSnapshotMessage{},
+	"task-result":        TaskResultMessage{},
+	"task-failed":        NoticeMessage{},
+	"chat-message":       ChatMessage{},
+	"meeting-called":     MeetingCalledMessage{},
+	"voting-time-update": TimeUpdateMessage{},
+	"vote-cast":          VoteCastMessage{},
+	"voting-ended":       VotingEndedMessage{},
+	"blame":              BlameMessage{},
+	"game-resumed":       GameResumedMessage{},
+	"game-ended":         GameEndedMessage{},
+	"session-resumed":    SessionResumedMessage{},
+	"spectate-joined":    SpectateJoinedMessage{},
+	"ghost-mode":         GhostModeMessage{},
+	"ghost-task-result":  GhostTaskResultMessage{},
+	"ghost-progress":     GhostProgressMessage{},
+	"queue-status":       QueueStatusMessage{},
+	"queue-left":         NoticeMessage{},
+	"match-found":        RoomJoinedMessage{},
+	"ready-check":        ReadyCheckMessage{},
+	"ready-check-failed": ReadyCheckFailedMessage{},
+	"rematch-status":     RematchStatusMessage{},
+	"back-to-lobby":      BackToLobbyMessage{},
+	"kicked":             KickedMessage{},
+	"room-closed":        RoomClosedMessage{},
+	"server-draining":    NoticeMessage{},
+	"replay-started":     ReplayStartedMessage{},
+	"replay-event":       ReplayEventMessage{},
+	"replay-seeked":      ReplaySeekedMessage{},
+	"replay-ended":       ReplayEndedMessage{},
+}
+
+func subprotocolName(version int) string {
+	return fmt.Sprintf("%s%d", subprotocolPrefix, version)
+}
+
+// Subprotocols lists the Sec-WebSocket-Protocol values the server accepts
+func Subprotocols() []string {
+	names := make([]string, 0, len(supportedProtocolVersions))
+	for _, version := range supportedProtocolVersions {
+		names = append(names, subprotocolName(version))
+	}
+	return names
+}
+
+// negotiateProtocol picks the version for a connection from the
+// subprotocols the client offered. A client that offers none gets the
+// current version; one that offers only unknown ones is refused.
+func negotiateProtocol(offered []string) (int, bool) {
+	if len(offered) == 0 {
+		return ProtocolVersion, true
+	}
+	for _, name := range offered {
+		for _, version := range supportedProtocolVersions {
+			if name == subprotocolName(version) {
+				return version, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// decodeData unmarshals a message's data into v. A missing data field
+// leaves v as it is.
+func decodeData(raw json.RawMessage, v interface{}) error {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	err := json.Unmarshal(raw, v)
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		// Don't leak Go type names to clients
+		return fmt.Errorf("%s must be %s, got %s", typeErr.Field, jsonKind(typeErr.Type), typeErr.Value)
+	}
+	return err
+}
+
+func jsonKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	}
+	return "an object"
+}
+
+// ServeProtocol handles GET /protocol.json, a JSON Schema description of
+// every message so clients can generate their types
+func ServeProtocol(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(describeProtocol())
+}
+
+func describeProtocol() map[string]interface{} {
+	defs := make(map[string]interface{})
+
+	inbound := make(map[string]interface{}, len(clientMessages))
+	for name, data := range clientMessages {
+		// Missing fields decode to their zero value, or for
+		// update-settings keep the current setting
+		dataSchema := schemaFor(reflect.TypeOf(data), defs, false)
+		delete(dataSchema, "required")
+		inbound[name] = map[string]interface{}{
+			"type":     "object",
+			"required": []string{"type"},
+			"properties": map[string]interface{}{
+				"type": map[string]interface{}{"const": name},
+				"data": dataSchema,
+			},
+		}
+	}
+
+	outbound := make(map[string]interface{}, len(serverMessages))
+	for name, msg := range serverMessages {
+		schema := schemaFor(reflect.TypeOf(msg), defs, false)
+		schema["properties"].(map[string]interface{})["type"] = map[string]interface{}{"const": name}
+		outbound[name] = schema
+	}
+
+	return map[string]interface{}{
+		"$schema":         "https://json-schema.org/draft/2020-12/schema",
+		"protocolVersion": ProtocolVersion,
+		"subprotocols":    Subprotocols(),
+		"inbound":         inbound,
+		"outbound":        outbound,
+		"errorCodes":      errorCodes,
+		"$defs":           defs,
+	}
+}
+
+var rawMessageType = reflect.TypeOf(json.RawMessage{})
+
+// schemaFor describes t the way encoding/json writes it. Named structs
+// other than the top-level message go into defs and are referenced.
+func schemaFor(t reflect.Type, defs map[string]interface{}, ref bool) map[string]interface{} {
+	if t == rawMessageType || t.Kind() == reflect.Interface {
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return map[string]interface{}{
+			"anyOf": []interface{}{schemaFor(t.Elem(), defs, true), map[string]interface{}{"type": "null"}},
+		}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemaFor(t.Elem(), defs, true)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaFor(t.Elem(), defs, true)}
+	case reflect.Struct:
+		if !ref || t.Name() == "" {
+			return structSchema(t, defs)
+		}
+		if _, ok := defs[t.Name()]; !ok {
+			defs[t.Name()] = nil // placeholder in case the type refers to itself
+			defs[t.Name()] = structSchema(t, defs)
+		}
+		return map[string]interface{}{"$ref": "#/$defs/" + t.Name()}
+	}
+	return map[string]interface{}{}
+}
+
+func structSchema(t reflect.Type, defs map[string]interface{}) map[string]interface{} {
+	properties := make(map[string]interface{})
+	required := make([]string, 0)
+	addStructFields(t, defs, properties, &required)
+	sort.Strings(required)
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}
+}
+
+// addStructFields flattens embedded structs the way encoding/json does
+func addStructFields(t reflect.Type, defs map[string]interface{}, properties map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() && !field.Anonymous {
+			continue
+		}
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			addStructFields(field.Type, defs, properties, required)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		schema := schemaFor(field.Type, defs, true)
+		omitEmpty := strings.Contains(options, "omitempty")
+		if omitEmpty && field.Type.Kind() == reflect.Ptr {
+			// Left out rather than sent as null
+			schema = schemaFor(field.Type.Elem(), defs, true)
+		}
+		properties[name] = schema
+		if !omitEmpty {
+			*required = append(*required, name)
+		}
+	}
+}
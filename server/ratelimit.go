@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// rateLimit is a token bucket: Burst messages at once, refilled at
+// PerSecond
+type rateLimit struct {
+	PerSecond float64
+	Burst     float64
+}
+
+// messageRateLimits bounds each client message type; anything not listed
+// uses defaultRateLimit. Typing sends a code-op per keystroke, so those
+// get the most room.
+var messageRateLimits = map[string]rateLimit{
+	"create-room":    {PerSecond: 0.2, Burst: 3},
+	"join-room":      {PerSecond: 1, Burst: 5},
+	"spectate-room":  {PerSecond: 1, Burst: 5},
+	"resume-session": {PerSecond: 1, Burst: 5},
+	"queue-join":     {PerSecond: 0.5, Burst: 3},
+	"queue-leave":    {PerSecond: 0.5, Burst: 3},
+	"replay":         {PerSecond: 1, Burst: 3},
+	"kick-player":    {PerSecond: 1, Burst: 5},
+	"transfer-host":  {PerSecond: 1, Burst: 3},
+	"code-op":        {PerSecond: 30, Burst: 60},
+	"code-update":    {PerSecond: 10, Burst: 20},
+	"code-sync":      {PerSecond: 1, Burst: 3},
+	"chat-message":   {PerSecond: 1, Burst: 5},
+	"call-meeting":   {PerSecond: 1, Burst: 3},
+	"blame":          {PerSecond: 1, Burst: 3},
+	"submit-task":    {PerSecond: 0.5, Burst: 2},
+	"ghost-task":     {PerSecond: 0.5, Burst: 2},
+}
+
+var defaultRateLimit = rateLimit{PerSecond: 5, Burst: 10}
+
+// identityRateLimits bound the /api/identity/ endpoints per IP address.
+// Guests and registrations each write a profile, and every log in costs
+// a password hash.
+var identityRateLimits = map[string]rateLimit{
+	"guest":    {PerSecond: 0.1, Burst: 5},
+	"register": {PerSecond: 0.05, Burst: 3},
+	"login":    {PerSecond: 0.2, Burst: 5},
+	"me":       {PerSecond: 2, Burst: 10},
+}
+
+// A client that keeps going over its limits is warned, then throttled,
+// then disconnected. Each rejected message is a strike; strikes wear off
+// one per strikeDecay.
+const (
+	warnStrikes       = 3
+	disconnectStrikes = 20
+	strikeDecay       = 2 * time.Second
+	throttlePeriod    = 5 * time.Second
+)
+
+type floodVerdict int
+
+const (
+	floodAllow floodVerdict = iota
+	floodWarn
+	floodThrottleStart
+	floodThrottled
+	floodDisconnect
+)
+
+type tokenBucket struct {
+	limit  rateLimit
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) take(now time.Time) (bool, time.Duration) {
+	b.tokens += now.Sub(b.last).Seconds() * b.limit.PerSecond
+	if b.tokens > b.limit.Burst {
+		b.tokens = b.limit.Burst
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / b.limit.PerSecond * float64(time.Second))
+	return false, wait
+}
+
+// ipRateLimiter keeps a token bucket per IP address and action for HTTP
+// endpoints. Buckets that have refilled are swept now and then so the
+// map doesn't grow with every address ever seen.
+type ipRateLimiter struct {
+	limits    map[string]rateLimit
+	buckets   map[string]*tokenBucket // "ip action" -> bucket
+	lastSweep time.Time
+	mutex     sync.Mutex
+}
+
+func newIPRateLimiter(limits map[string]rateLimit) *ipRateLimiter {
+	return &ipRateLimiter{limits: limits, buckets: make(map[string]*tokenBucket)}
+}
+
+// allow takes a token for action from ip. The duration is how long
+// until it may try again.
+func (l *ipRateLimiter) allow(ip, action string) (bool, time.Duration) {
+	limit, ok := l.limits[action]
+	if !ok {
+		limit = defaultRateLimit
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.lastSweep) > time.Minute {
+		l.sweepLocked(now)
+	}
+	key := ip + " " + action
+	bucket := l.buckets[key]
+	if bucket == nil {
+		bucket = &tokenBucket{limit: limit, tokens: limit.Burst, last: now}
+		l.buckets[key] = bucket
+	}
+	return bucket.take(now)
+}
+
+// remoteIP is the address r came from. Behind a reverse proxy every
+// request comes from the proxy, so header names the one it puts the
+// client's address in. A proxy adding to X-Forwarded-For appends, so the
+// last address is the one it saw; anything before it is up to the client.
+func remoteIP(r *http.Request, header string) string {
+	if header != "" {
+		if forwarded := r.Header.Values(header); len(forwarded) > 0 {
+			last := forwarded[len(forwarded)-1]
+			if i := strings.LastIndex(last, ","); i >= 0 {
+				last = last[i+1:]
+			}
+			if ip := strings.TrimSpace(last); ip != "" {
+				return ip
+			}
+		}
+	}
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// sweepLocked drops buckets that would be full again.
+// Caller must hold l.mutex.
+func (l *ipRateLimiter) sweepLocked(now time.Time) {
+	for key, bucket := range l.buckets {
+		refill := time.Duration(bucket.limit.Burst / bucket.limit.PerSecond * float64(time.Second))
+		if now.Sub(bucket.last) > refill {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// floodControl tracks one client's buckets and strikes. Only readPump
+// touches it, so it needs no lock.
+type floodControl struct {
+	buckets        map[string]*tokenBucket
+	strikes        float64
+	lastStrike     time.Time
+	throttledUntil time.Time
+	rejected       int
+	disconnected   bool
+}
+
+func newFloodControl() *floodControl {
+	return &floodControl{buckets: make(map[string]*tokenBucket)}
+}
+
+// check decides what to do with a message of the given type. The
+// duration is how long until the client may send it again.
+func (f *floodControl) check(messageType string, now time.Time) (floodVerdict, time.Duration) {
+	bucket := f.buckets[messageType]
+	if bucket == nil {
+		limit, ok := messageRateLimits[messageType]
+		if !ok {
+			limit = defaultRateLimit
+		}
+		bucket = &tokenBucket{limit: limit, tokens: limit.Burst, last: now}
+		f.buckets[messageType] = bucket
+	}
+
+	throttled := now.Before(f.throttledUntil)
+	allowed, wait := bucket.take(now)
+	if allowed && !throttled {
+		return floodAllow, 0
+	}
+
+	f.rejected++
+	f.strikes -= now.Sub(f.lastStrike).Seconds() / strikeDecay.Seconds()
+	if f.strikes < 0 {
+		f.strikes = 0
+	}
+	f.strikes++
+	f.lastStrike = now
+
+	switch {
+	case f.strikes >= disconnectStrikes:
+		f.disconnected = true
+		return floodDisconnect, 0
+	case throttled:
+		return floodThrottled, f.throttledUntil.Sub(now)
+	case f.strikes > warnStrikes:
+		f.throttledUntil = now.Add(throttlePeriod)
+		return floodThrottleStart, throttlePeriod
+	}
+	return floodWarn, wait
+}
+
+// allowMessage applies the client's rate limits and reports whether the
+// message may be handled. Warnings and the start of throttling get an
+// error reply; messages dropped while throttled get none, so a flood
+// can't be turned around on the client.
+func (c *Client) allowMessage(messageType string) bool {
+	if c.flood.disconnected {
+		return false
+	}
+	verdict, wait := c.flood.check(messageType, time.Now())
+	if verdict == floodAllow {
+		return true
+	}
+
+	reason := "rate_limited"
+	if verdict == floodThrottled {
+		reason = "throttled"
+	}
+	c.hub.metrics.messagesRejected.Add(1, messageType, reason)
+
+	switch verdict {
+	case floodWarn:
+		c.sendRateLimited(wait, "Slow down!")
+
+	case floodThrottleStart:
+		log.Printf("🚦 [LGTM] Throttling client %s for %v after %d rejected messages (last: %s)", c.id, throttlePeriod, c.flood.rejected, messageType)
+		c.sendRateLimited(wait, "Too many messages, ignoring you for a few seconds!")
+
+	case floodDisconnect:
+		log.Printf("🚫 [LGTM] Disconnecting client %s for flooding after %d rejected messages (last: %s)", c.id, c.flood.rejected, messageType)
+		message := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "rate limit exceeded")
+		c.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(c.config.WriteWait.D()))
+		// readPump sees the closed conn and unregisters the client
+		c.conn.Close()
+	}
+	return false
+}
+
+// rejectTooLarge refuses a chat message or code over the size limits.
+// size is -1 when it isn't known, as for an op that would grow the code.
+func (c *Client) rejectTooLarge(what, unit string, size, limit int) {
+	c.hub.metrics.messagesRejected.Add(1, c.handling, "too_large")
+	if size >= 0 {
+		log.Printf("📏 [LGTM] Rejected %s from client %s: %s is %d %s, limit %d", c.handling, c.id, strings.ToLower(what), size, unit, limit)
+	} else {
+		log.Printf("📏 [LGTM] Rejected %s from client %s: %s would exceed %d %s", c.handling, c.id, strings.ToLower(what), limit, unit)
+	}
+	c.sendError(ErrCodeTooLarge, fmt.Sprintf("%s can be at most %d %s!", what, limit, unit))
+}
+
+// sendRateLimited doesn't wait for queue space; a flooding client may not
+// be reading
+func (c *Client) sendRateLimited(wait time.Duration, message string) {
+	data, _ := json.Marshal(ErrorMessage{
+		Type:         "error",
+		Code:         ErrCodeRateLimited,
+		Message:      message,
+		MessageType:  c.handling,
+		RetryAfterMs: wait.Milliseconds(),
+	})
+	c.trySend(data)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	start := time.Unix(0, 0)
+	bucket := &tokenBucket{limit: rateLimit{PerSecond: 2, Burst: 3}, tokens: 3, last: start}
+
+	tests := []struct {
+		after   time.Duration
+		allowed bool
+		wait    time.Duration
+	}{
+		{0, true, 0},
+		{0, true, 0},
+		{0, true, 0},
+		{0, false, 500 * time.Millisecond},
+		{250 * time.Millisecond, false, 250 * time.Millisecond},
+		{500 * time.Millisecond, true, 0},
+		// Refilling stops at the burst
+		{10 * time.Second, true, 0},
+		{10 * time.Second, true, 0},
+		{10 * time.Second, true, 0},
+		{10 * time.Second, false, 500 * time.Millisecond},
+	}
+	for i, tt := range tests {
+		allowed, wait := bucket.take(start.Add(tt.after))
+		if allowed != tt.allowed || wait != tt.wait {
+			t.Errorf("take %d at +%v: got %v, %v; want %v, %v", i, tt.after, allowed, wait, tt.allowed, tt.wait)
+		}
+	}
+}
+
+func TestFloodControlStrikes(t *testing.T) {
+	// chat-message allows a burst of 5, then one a second
+	start := time.Unix(0, 0)
+	tests := []struct {
+		name  string
+		after time.Duration
+		n     int
+		want  floodVerdict
+	}{
+		{"burst", 0, 5, floodAllow},
+		{"first strikes warn", 0, warnStrikes, floodWarn},
+		{"one more throttles", 0, 1, floodThrottleStart},
+		{"dropped while throttled", time.Second, 1, floodThrottled},
+		{"burst refilled once throttling ends", throttlePeriod + time.Second, 5, floodAllow},
+		// 4.5 strikes at +1s have decayed to 2
+		{"decayed strikes warn", throttlePeriod + time.Second, 1, floodWarn},
+		{"and throttle sooner", throttlePeriod + time.Second, 1, floodThrottleStart},
+		{"burst refilled again", time.Minute, 5, floodAllow},
+		{"strikes have worn off", time.Minute, 1, floodWarn},
+	}
+
+	f := newFloodControl()
+	for _, tt := range tests {
+		for i := 0; i < tt.n; i++ {
+			if got, _ := f.check("chat-message", start.Add(tt.after)); got != tt.want {
+				t.Fatalf("%s: message %d got verdict %d, want %d", tt.name, i, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestFloodControlDisconnects(t *testing.T) {
+	now := time.Unix(0, 0)
+	f := newFloodControl()
+	for i := 0; i < 5; i++ {
+		f.check("chat-message", now)
+	}
+	for i := 1; i < disconnectStrikes; i++ {
+		if got, _ := f.check("chat-message", now); got == floodDisconnect {
+			t.Fatalf("disconnected after %d strikes, want %d", i, disconnectStrikes)
+		}
+	}
+	if got, _ := f.check("chat-message", now); got != floodDisconnect {
+		t.Fatalf("got verdict %d after %d strikes, want disconnect", got, disconnectStrikes)
+	}
+	if !f.disconnected {
+		t.Error("disconnected isn't set")
+	}
+}
+
+func TestFloodControlBucketsPerType(t *testing.T) {
+	now := time.Unix(0, 0)
+	f := newFloodControl()
+	for i := 0; i < 5; i++ {
+		f.check("chat-message", now)
+	}
+	if got, _ := f.check("chat-message", now); got != floodWarn {
+		t.Fatalf("got verdict %d, want warn", got)
+	}
+	if got, _ := f.check("code-op", now); got != floodAllow {
+		t.Errorf("code-op got verdict %d after chat ran out, want allow", got)
+	}
+}
+
+func TestRemoteIP(t *testing.T) {
+	tests := []struct {
+		name      string
+		header    string
+		forwarded []string
+		want      string
+	}{
+		{"no proxy", "", nil, "203.0.113.7"},
+		{"header ignored without a proxy", "", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"proxy header", "X-Forwarded-For", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"last address wins", "X-Forwarded-For", []string{"10.0.0.1, 198.51.100.1"}, "198.51.100.1"},
+		{"last header wins", "X-Forwarded-For", []string{"10.0.0.1", "198.51.100.1"}, "198.51.100.1"},
+		{"other header", "X-Real-IP", []string{" 198.51.100.2 "}, "198.51.100.2"},
+		{"header missing", "X-Forwarded-For", nil, "203.0.113.7"},
+		{"header empty", "X-Forwarded-For", []string{"10.0.0.1, "}, "203.0.113.7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/api/login", nil)
+			r.RemoteAddr = "203.0.113.7:52100"
+			for _, value := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+				r.Header.Add("X-Real-IP", value)
+			}
+			if got := remoteIP(r, tt.header); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Players with an identity are rated separately as engineers and as
+// impostors. After each game the two sides are scored like an Elo match:
+// each side's strength is the average rating of its players in the role
+// they played, and every signed-in player moves by K times the difference
+// between the result and what was expected. New players move faster
+// until they have played provisionalGames in a role. Players without an
+// identity count as initialRating and aren't stored.
+
+const (
+	initialRating    = 1500
+	provisionalGames = 10
+	kProvisional     = 40
+	kEstablished     = 20
+	maxStoredMatches = 10000
+)
+
+var errRatingsNotFound = errors.New("player has no ratings")
+
+// RoleRating is a player's standing in one role
+type RoleRating struct {
+	Rating float64 `json:"rating"`
+	Games  int     `json:"games"`
+	Wins   int     `json:"wins"`
+}
+
+// PlayerRatings holds both of a player's role ratings
+type PlayerRatings struct {
+	PlayerID string     `json:"playerId"`
+	Name     string     `json:"name"` // as of their last game
+	Engineer RoleRating `json:"engineer"`
+	Impostor RoleRating `json:"impostor"`
+}
+
+func newPlayerRatings(playerID, name string) *PlayerRatings {
+	return &PlayerRatings{
+		PlayerID: playerID,
+		Name:     name,
+		Engineer: RoleRating{Rating: initialRating},
+		Impostor: RoleRating{Rating: initialRating},
+	}
+}
+
+// Role returns the rating for "engineer" or "impostor"
+func (p *PlayerRatings) Role(role string) *RoleRating {
+	if role == "impostor" {
+		return &p.Impostor
+	}
+	return &p.Engineer
+}
+
+// MatchRecord is a finished game as kept for match history
+type MatchRecord struct {
+	GameID   string        `json:"gameId"`
+	RoomCode string        `json:"roomCode"`
+	EndedAt  int64         `json:"endedAt"` // Unix ms
+	Winner   string        `json:"winner"`
+	Cause    string        `json:"cause"`
+	Players  []MatchPlayer `json:"players"`
+}
+
+type MatchPlayer struct {
+	ID     string  `json:"id"`
+	Name   string  `json:"name"`
+	Role   string  `json:"role"`
+	Won    bool    `json:"won"`
+	Rated  bool    `json:"rated"`            // signed in, so the game counted
+	Rating float64 `json:"rating,omitempty"` // role rating after the game
+	Change float64 `json:"change,omitempty"`
+}
+
+// RatingStore keeps ratings and match history. FileRatingStore is the
+// default. Ratings come back as copies; matches don't change once
+// recorded, so they may be shared.
+type RatingStore interface {
+	Get(playerID string) (*PlayerRatings, error)
+	All() ([]*PlayerRatings, error)
+	// Record saves a match together with the ratings it changed
+	Record(match *MatchRecord, ratings []*PlayerRatings) error
+	// Matches lists matches that ended at or after since, newest first.
+	// A non-empty playerID keeps only that player's; limit 0 is no limit.
+	Matches(playerID string, since time.Time, limit int) ([]*MatchRecord, error)
+}
+
+// FileRatingStore keeps ratings and the most recent maxStoredMatches
+// matches in memory and rewrites one JSON file on each game
+type FileRatingStore struct {
+	path    string
+	players map[string]*PlayerRatings
+	matches []*MatchRecord // oldest first
+	mutex   sync.RWMutex
+}
+
+// NewFileRatingStore loads the ratings in path; a missing file is an
+// empty store
+func NewFileRatingStore(path string) (*FileRatingStore, error) {
+	s := &FileRatingStore{
+		path:    path,
+		players: make(map[string]*PlayerRatings),
+		matches: make([]*MatchRecord, 0),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Players []*PlayerRatings `json:"players"`
+		Matches []*MatchRecord   `json:"matches"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	for _, p := range file.Players {
+		s.players[p.PlayerID] = p
+	}
+	if file.Matches != nil {
+		s.matches = file.Matches
+	}
+	return s, nil
+}
+
+func (s *FileRatingStore) Get(playerID string) (*PlayerRatings, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	p, ok := s.players[playerID]
+	if !ok {
+		return nil, errRatingsNotFound
+	}
+	copied := *p
+	return &copied, nil
+}
+
+func (s *FileRatingStore) All() ([]*PlayerRatings, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	all := make([]*PlayerRatings, 0, len(s.players))
+	for _, p := range s.players {
+		copied := *p
+		all = append(all, &copied)
+	}
+	return all, nil
+}
+
+func (s *FileRatingStore) Record(match *MatchRecord, ratings []*PlayerRatings) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, p := range ratings {
+		copied := *p
+		s.players[p.PlayerID] = &copied
+	}
+	s.matches = append(s.matches, match)
+	if len(s.matches) > maxStoredMatches {
+		s.matches = s.matches[len(s.matches)-maxStoredMatches:]
+	}
+	return s.writeLocked()
+}
+
+func (s *FileRatingStore) Matches(playerID string, since time.Time, limit int) ([]*MatchRecord, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	sinceMillis := since.UnixMilli()
+	matches := make([]*MatchRecord, 0)
+	for i := len(s.matches) - 1; i >= 0; i-- {
+		match := s.matches[i]
+		if match.EndedAt < sinceMillis {
+			break
+		}
+		if playerID != "" && !match.hasPlayer(playerID) {
+			continue
+		}
+		matches = append(matches, match)
+		if limit > 0 && len(matches) == limit {
+			break
+		}
+	}
+	return matches, nil
+}
+
+func (m *MatchRecord) hasPlayer(playerID string) bool {
+	for _, p := range m.Players {
+		if p.ID == playerID {
+			return true
+		}
+	}
+	return false
+}
+
+// writeLocked replaces the file through a temporary one.
+// Caller must hold s.mutex.
+func (s *FileRatingStore) writeLocked() error {
+	players := make([]*PlayerRatings, 0, len(s.players))
+	for _, p := range s.players {
+		players = append(players, p)
+	}
+	sort.Slice(players, func(i, j int) bool {
+		return players[i].PlayerID < players[j].PlayerID
+	})
+	data, err := json.Marshal(map[string]interface{}{
+		"players": players,
+		"matches": s.matches,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// Ratings scores finished games into a store
+type Ratings struct {
+	store   RatingStore
+	mutex   sync.Mutex     // one game is scored at a time
+	pending sync.WaitGroup // RecordGameAsync calls not yet saved
+}
+
+func NewRatings(store RatingStore) *Ratings {
+	return &Ratings{store: store}
+}
+
+// matchRecordLocked describes the game that just ended for RecordGame;
+// ok is false if nobody in it was signed in. Caller must hold r.mutex.
+func (r *Room) matchRecordLocked(gameID, winner, cause string) (*MatchRecord, bool) {
+	match := &MatchRecord{
+		GameID:   gameID,
+		RoomCode: r.code,
+		EndedAt:  time.Now().UnixMilli(),
+		Winner:   winner,
+		Cause:    cause,
+		Players:  make([]MatchPlayer, 0, len(r.players)+len(r.detached)),
+	}
+	rated := false
+	for _, p := range r.allPlayersLocked() {
+		match.Players = append(match.Players, MatchPlayer{
+			ID:    p.ID,
+			Name:  p.Name,
+			Role:  p.Role,
+			Won:   (p.Role == "impostor") == (winner == "impostor"),
+			Rated: p.rated,
+		})
+		rated = rated || p.rated
+	}
+	return match, rated
+}
+
+// RecordGameAsync scores match in the background. EndGame calls it with
+// the room locked, so a drain that sees no game in progress can Wait for
+// the last ratings to be saved.
+func (rt *Ratings) RecordGameAsync(match *MatchRecord) {
+	rt.pending.Add(1)
+	go func() {
+		defer rt.pending.Done()
+		rt.RecordGame(match)
+	}()
+}
+
+// Wait blocks until every game passed to RecordGameAsync is saved
+func (rt *Ratings) Wait() {
+	rt.pending.Wait()
+}
+
+// RecordGame updates the ratings of the signed-in players in a finished
+// game and saves it to their match history
+func (rt *Ratings) RecordGame(match *MatchRecord) {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+
+	// Current ratings, with a fresh one for anyone not yet rated
+	ratings := make(map[string]*PlayerRatings)
+	for _, p := range match.Players {
+		if !p.Rated {
+			continue
+		}
+		current, err := rt.store.Get(p.ID)
+		if errors.Is(err, errRatingsNotFound) {
+			current = newPlayerRatings(p.ID, p.Name)
+		} else if err != nil {
+			log.Printf("[LGTM] Failed to load ratings for %s: %v", p.ID, err)
+			return
+		}
+		current.Name = p.Name
+		ratings[p.ID] = current
+	}
+
+	// Each side's strength in the roles it played
+	strength := map[bool][]float64{}
+	for _, p := range match.Players {
+		rating := float64(initialRating)
+		if current, ok := ratings[p.ID]; ok {
+			rating = current.Role(p.Role).Rating
+		}
+		strength[p.Won] = append(strength[p.Won], rating)
+	}
+	expectedWin := eloExpected(average(strength[true]), average(strength[false]))
+
+	updated := make([]*PlayerRatings, 0, len(ratings))
+	for i, p := range match.Players {
+		current, ok := ratings[p.ID]
+		if !ok {
+			continue
+		}
+		role := current.Role(p.Role)
+		k := float64(kEstablished)
+		if role.Games < provisionalGames {
+			k = kProvisional
+		}
+		change := k * (1 - expectedWin)
+		if !p.Won {
+			change = -k * expectedWin
+		}
+		change = math.Round(change*10) / 10
+
+		role.Rating += change
+		role.Games++
+		if p.Won {
+			role.Wins++
+		}
+		match.Players[i].Rating = role.Rating
+		match.Players[i].Change = change
+		updated = append(updated, current)
+	}
+
+	if err := rt.store.Record(match, updated); err != nil {
+		log.Printf("[LGTM] Failed to save ratings for game %s: %v", match.GameID, err)
+		return
+	}
+	log.Printf("📈 [LGTM] Rated game %s: %d player(s) updated", match.GameID, len(updated))
+}
+
+// eloExpected is the chance the side rated a beats the side rated b
+func eloExpected(a, b float64) float64 {
+	return 1 / (1 + math.Pow(10, (b-a)/400))
+}
+
+func average(values []float64) float64 {
+	if len(values) == 0 {
+		return initialRating
+	}
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEloExpected(t *testing.T) {
+	tests := []struct {
+		a, b float64
+		want float64
+	}{
+		{1500, 1500, 0.5},
+		{1900, 1500, 1 / 1.1},
+		{1500, 1900, 1 - 1/1.1},
+		{2300, 1500, 1 / 1.01},
+	}
+	for _, tt := range tests {
+		got := eloExpected(tt.a, tt.b)
+		if math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("eloExpected(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+		if sum := got + eloExpected(tt.b, tt.a); math.Abs(sum-1) > 1e-9 {
+			t.Errorf("eloExpected(%v, %v) and its reverse sum to %v, want 1", tt.a, tt.b, sum)
+		}
+	}
+}
+
+func TestRecordGame(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ratings.json")
+	store, err := NewFileRatingStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// imp is established as an impostor; e1 is still provisional
+	imp := newPlayerRatings("imp", "Imp")
+	imp.Impostor = RoleRating{Rating: 1700, Games: 12, Wins: 8}
+	e1 := newPlayerRatings("e1", "E1")
+	e1.Engineer = RoleRating{Rating: 1500, Games: 3, Wins: 1}
+	e2 := newPlayerRatings("e2", "E2")
+	e2.Engineer = RoleRating{Rating: 1300, Games: 20, Wins: 9}
+	store.players = map[string]*PlayerRatings{"imp": imp, "e1": e1, "e2": e2}
+
+	// The engineers average 1450 against 1700, counting anon and new as
+	// 1500, so expect to win 19.2% of the time
+	match := &MatchRecord{
+		GameID:  "game-1",
+		EndedAt: 1,
+		Winner:  "engineer",
+		Players: []MatchPlayer{
+			{ID: "imp", Name: "Imp", Role: "impostor", Won: false, Rated: true},
+			{ID: "e1", Name: "E1", Role: "engineer", Won: true, Rated: true},
+			{ID: "e2", Name: "E2", Role: "engineer", Won: true, Rated: true},
+			{ID: "anon", Name: "Anon", Role: "engineer", Won: true},
+			{ID: "new", Name: "New", Role: "engineer", Won: true, Rated: true},
+		},
+	}
+	NewRatings(store).RecordGame(match)
+
+	want := []struct {
+		id     string
+		change float64
+		rating RoleRating
+	}{
+		{"imp", -3.8, RoleRating{Rating: 1696.2, Games: 13, Wins: 8}},
+		{"e1", 32.3, RoleRating{Rating: 1532.3, Games: 4, Wins: 2}},
+		{"e2", 16.2, RoleRating{Rating: 1316.2, Games: 21, Wins: 10}},
+		{"anon", 0, RoleRating{}},
+		{"new", 32.3, RoleRating{Rating: 1532.3, Games: 1, Wins: 1}},
+	}
+
+	reloaded, err := NewFileRatingStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := 0.0
+	for i, w := range want {
+		p := match.Players[i]
+		if p.ID != w.id {
+			t.Fatalf("player %d is %s, want %s", i, p.ID, w.id)
+		}
+		if math.Abs(p.Change-w.change) > 1e-9 || math.Abs(p.Rating-w.rating.Rating) > 1e-9 {
+			t.Errorf("%s: change %v to %v, want %v to %v", w.id, p.Change, p.Rating, w.change, w.rating.Rating)
+		}
+		total += p.Change
+
+		got, err := reloaded.Get(w.id)
+		if w.rating == (RoleRating{}) {
+			if !errors.Is(err, errRatingsNotFound) {
+				t.Errorf("%s: unrated player was stored", w.id)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", w.id, err)
+		}
+		role := got.Role(p.Role)
+		if math.Abs(role.Rating-w.rating.Rating) > 1e-9 || role.Games != w.rating.Games || role.Wins != w.rating.Wins {
+			t.Errorf("%s: stored %+v, want %+v", w.id, *role, w.rating)
+		}
+	}
+	if want := -3.8 + 32.3 + 16.2 + 32.3; math.Abs(total-want) > 1e-9 {
+		t.Errorf("changes sum to %v, want %v", total, want)
+	}
+
+	matches, _ := reloaded.Matches("e2", time.UnixMilli(0), 0)
+	if len(matches) != 1 || matches[0].GameID != "game-1" {
+		t.Errorf("e2's match history is %+v, want game-1", matches)
+	}
+}
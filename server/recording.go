@@ -0,0 +1,410 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const recordingCacheSize = 50
+
+var errRecordingNotFound = errors.New("recording not found")
+
+// replaySpeeds are the playback multipliers a client may ask for
+var replaySpeeds = map[int]bool{1: true, 4: true, 16: true}
+
+type GameEvent struct {
+	Offset    int64           `json:"offset"`    // ms since game start
+	Timestamp int64           `json:"timestamp"` // unix ms
+	Type      string          `json:"type"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// GameLog is the append-only record of one game, from role assignment
+// to the result. Events are written to its file as they happen, so a
+// crash or restart mid-game keeps what was played so far.
+type GameLog struct {
+	ID        string      `json:"id"`
+	RoomCode  string      `json:"roomCode"`
+	StartedAt int64       `json:"startedAt"`
+	EndedAt   int64       `json:"endedAt"`
+	Winner    string      `json:"winner"`
+	Events    []GameEvent `json:"events"`
+
+	file *os.File // nil once closed, or if it couldn't be written
+}
+
+// gameLogHeader is the first line of a recording file; every line after
+// it is a GameEvent
+type gameLogHeader struct {
+	ID        string `json:"id"`
+	RoomCode  string `json:"roomCode"`
+	StartedAt int64  `json:"startedAt"`
+}
+
+func NewGameLog(roomCode string) *GameLog {
+	return &GameLog{
+		ID:        uuid.New().String(),
+		RoomCode:  roomCode,
+		StartedAt: time.Now().UnixMilli(),
+		Events:    make([]GameEvent, 0),
+	}
+}
+
+// Append records an outgoing message and returns its type
+func (l *GameLog) Append(data []byte) string {
+	var header struct {
+		Type   string `json:"type"`
+		Winner string `json:"winner"`
+	}
+	json.Unmarshal(data, &header)
+
+	now := time.Now().UnixMilli()
+	event := GameEvent{
+		Offset:    now - l.StartedAt,
+		Timestamp: now,
+		Type:      header.Type,
+		Data:      append(json.RawMessage{}, data...),
+	}
+	l.Events = append(l.Events, event)
+	l.write(event)
+
+	if header.Type == "game-ended" {
+		l.EndedAt = now
+		l.Winner = header.Winner
+	}
+	return header.Type
+}
+
+// write appends one line to the log's file. After a failed write the
+// game is only kept in memory.
+func (l *GameLog) write(line interface{}) {
+	if l.file == nil {
+		return
+	}
+	data, _ := json.Marshal(line)
+	if _, err := l.file.Write(append(data, '\n')); err != nil {
+		log.Printf("[LGTM] Failed to write recording %s, keeping the rest in memory: %v", l.ID, err)
+		l.close()
+	}
+}
+
+func (l *GameLog) close() {
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+}
+
+// RecordingStore keeps game logs as one JSON Lines file per game, with a
+// small cache of recently finished ones
+type RecordingStore struct {
+	dir   string
+	cache map[string]*GameLog
+	order []string
+	mutex sync.Mutex
+}
+
+func NewRecordingStore(dir string) *RecordingStore {
+	return &RecordingStore{
+		dir:   dir,
+		cache: make(map[string]*GameLog),
+	}
+}
+
+func (s *RecordingStore) path(id string) string {
+	return filepath.Join(s.dir, id+".jsonl")
+}
+
+// Create starts the log for a new game and its file. If the file can't
+// be created the game is still recorded in memory.
+func (s *RecordingStore) Create(roomCode string) *GameLog {
+	gameLog := NewGameLog(roomCode)
+	err := os.MkdirAll(s.dir, 0o700)
+	if err == nil {
+		gameLog.file, err = os.OpenFile(s.path(gameLog.ID), os.O_WRONLY|os.O_CREATE|os.O_EXCL|os.O_APPEND, 0o600)
+	}
+	if err != nil {
+		log.Printf("[LGTM] Failed to create recording %s, keeping it in memory: %v", gameLog.ID, err)
+		return gameLog
+	}
+	gameLog.write(gameLogHeader{ID: gameLog.ID, RoomCode: roomCode, StartedAt: gameLog.StartedAt})
+	return gameLog
+}
+
+// Finish closes a game's file and caches the log for replays
+func (s *RecordingStore) Finish(gameLog *GameLog) {
+	gameLog.close()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.cache[gameLog.ID] = gameLog
+	s.order = append(s.order, gameLog.ID)
+	if len(s.order) > recordingCacheSize {
+		delete(s.cache, s.order[0])
+		s.order = s.order[1:]
+	}
+}
+
+func (s *RecordingStore) Load(id string) (*GameLog, error) {
+	// IDs are UUIDs; anything else could walk out of the directory
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, errRecordingNotFound
+	}
+
+	s.mutex.Lock()
+	cached := s.cache[id]
+	s.mutex.Unlock()
+	if cached != nil {
+		return cached, nil
+	}
+
+	data, err := os.ReadFile(s.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errRecordingNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseGameLog(data)
+}
+
+// parseGameLog reads a recording file. A game that is still going, or
+// was cut off by a crash, has no result; a line cut off halfway is
+// dropped.
+func parseGameLog(data []byte) (*GameLog, error) {
+	lines := bytes.Split(bytes.TrimRight(data, "\n"), []byte("\n"))
+	var header gameLogHeader
+	if err := json.Unmarshal(lines[0], &header); err != nil {
+		return nil, err
+	}
+	gameLog := &GameLog{
+		ID:        header.ID,
+		RoomCode:  header.RoomCode,
+		StartedAt: header.StartedAt,
+		Events:    make([]GameEvent, 0, len(lines)-1),
+	}
+	for i, line := range lines[1:] {
+		var event GameEvent
+		if err := json.Unmarshal(line, &event); err != nil {
+			if i == len(lines)-2 {
+				break
+			}
+			return nil, err
+		}
+		gameLog.Events = append(gameLog.Events, event)
+		if event.Type == "game-ended" {
+			var ended struct {
+				Winner string `json:"winner"`
+			}
+			json.Unmarshal(event.Data, &ended)
+			gameLog.EndedAt = event.Timestamp
+			gameLog.Winner = ended.Winner
+		}
+	}
+	return gameLog, nil
+}
+
+// ServeGameLog handles GET /api/games/{id}
+func ServeGameLog(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/api/games/")
+	gameLog, err := hub.recordings.Load(id)
+	if errors.Is(err, errRecordingNotFound) {
+		http.Error(w, "game not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("[LGTM] Failed to load recording %s: %v", id, err)
+		http.Error(w, "failed to load game", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(gameLog)
+}
+
+// recordLocked appends a message to the current game's log and finishes
+// the log once the result is in. Caller must hold r.mutex.
+func (r *Room) recordLocked(data []byte) {
+	if r.recording == nil {
+		return
+	}
+	if r.recording.Append(data) == "game-ended" {
+		r.hub.recordings.Finish(r.recording)
+		r.recording = nil
+	}
+}
+
+// replayControl is a playback command from the client
+type replayControl struct {
+	seek  int64 // ms offset, -1 to keep position
+	speed int   // 0 to keep speed
+	stop  bool
+}
+
+type replaySession struct {
+	control chan replayControl
+	// stopped is checked before every message, so a stop also cuts
+	// short a seek that is resending the log
+	stopped atomic.Bool
+}
+
+// StartReplay streams a finished game's events back to the client at the
+// requested speed. Seeking resends everything up to the new offset at
+// once so the client can rebuild its state.
+func (c *Client) StartReplay(gameLog *GameLog, speed int) {
+	session := &replaySession{control: make(chan replayControl, 8)}
+	c.replayMutex.Lock()
+	c.stopReplayLocked()
+	c.replay = session
+	c.replayMutex.Unlock()
+
+	go c.runReplay(session, gameLog, speed)
+}
+
+func (c *Client) ControlReplay(ctrl replayControl) {
+	c.replayMutex.Lock()
+	defer c.replayMutex.Unlock()
+	if ctrl.stop {
+		c.stopReplayLocked()
+		return
+	}
+	if c.replay == nil {
+		return
+	}
+	select {
+	case c.replay.control <- ctrl:
+	default:
+	}
+}
+
+// StopReplay ends the client's replay, if any. Joining or watching a room
+// stops it so room messages don't queue behind replay events.
+func (c *Client) StopReplay() {
+	c.ControlReplay(replayControl{stop: true})
+}
+
+// stopReplayLocked stops the running replay. Caller must hold
+// c.replayMutex.
+func (c *Client) stopReplayLocked() {
+	if c.replay == nil {
+		return
+	}
+	c.replay.stopped.Store(true)
+	select {
+	case c.replay.control <- replayControl{stop: true}:
+	default:
+	}
+	c.replay = nil
+}
+
+func (c *Client) runReplay(session *replaySession, gameLog *GameLog, speed int) {
+	defer func() {
+		c.replayMutex.Lock()
+		if c.replay == session {
+			c.replay = nil
+		}
+		c.replayMutex.Unlock()
+	}()
+
+	duration := int64(0)
+	if n := len(gameLog.Events); n > 0 {
+		duration = gameLog.Events[n-1].Offset
+	}
+
+	send := func(msg interface{}) bool {
+		if session.stopped.Load() {
+			return false
+		}
+		data, _ := json.Marshal(msg)
+		return c.deliver(data)
+	}
+	if !send(ReplayStartedMessage{
+		Type:       "replay-started",
+		GameID:     gameLog.ID,
+		RoomCode:   gameLog.RoomCode,
+		Duration:   duration,
+		EventCount: len(gameLog.Events),
+		Speed:      speed,
+	}) {
+		return
+	}
+
+	next := 0
+	position := int64(0)
+	for next < len(gameLog.Events) {
+		event := gameLog.Events[next]
+		wait := time.Duration(event.Offset-position) * time.Millisecond / time.Duration(speed)
+		timer := time.NewTimer(wait)
+		waitStarted := time.Now()
+
+		select {
+		case <-c.done:
+			timer.Stop()
+			return
+
+		case <-timer.C:
+			position = event.Offset
+			if !send(ReplayEventMessage{
+				Type:   "replay-event",
+				Offset: event.Offset,
+				Event:  event.Data,
+			}) {
+				return
+			}
+			next++
+
+		case ctrl := <-session.control:
+			timer.Stop()
+			if ctrl.stop {
+				return
+			}
+			// Account for playback time that passed before the command
+			elapsed := time.Since(waitStarted).Milliseconds() * int64(speed)
+			position = min(position+elapsed, event.Offset)
+			if ctrl.speed != 0 {
+				speed = ctrl.speed
+			}
+			if ctrl.seek >= 0 {
+				position = min(ctrl.seek, duration)
+				send(ReplaySeekedMessage{
+					Type:   "replay-seeked",
+					Offset: position,
+				})
+				next = 0
+				for next < len(gameLog.Events) && gameLog.Events[next].Offset <= position {
+					e := gameLog.Events[next]
+					if !send(ReplayEventMessage{
+						Type:   "replay-event",
+						Offset: e.Offset,
+						Event:  e.Data,
+						Seek:   true,
+					}) {
+						return
+					}
+					next++
+				}
+			}
+		}
+	}
+
+	send(ReplayEndedMessage{
+		Type:   "replay-ended",
+		GameID: gameLog.ID,
+	})
+}
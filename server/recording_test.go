@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRecordingStore(t *testing.T) {
+	dir := t.TempDir()
+	store := NewRecordingStore(dir)
+	gameLog := store.Create("ABCD")
+	gameLog.Append([]byte(`{"type":"game-started"}`))
+	gameLog.Append([]byte(`{"type":"chat-message","message":"hi"}`))
+
+	// Events are on disk before the game ends
+	inProgress, err := NewRecordingStore(dir).Load(gameLog.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inProgress.Events) != 2 || inProgress.EndedAt != 0 {
+		t.Errorf("game in progress loaded with %d events, ended at %d; want 2, 0", len(inProgress.Events), inProgress.EndedAt)
+	}
+
+	gameLog.Append([]byte(`{"type":"game-ended","winner":"impostor"}`))
+	store.Finish(gameLog)
+
+	loaded, err := NewRecordingStore(dir).Load(gameLog.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.RoomCode != "ABCD" || loaded.StartedAt != gameLog.StartedAt {
+		t.Errorf("loaded room %s started %d, want ABCD started %d", loaded.RoomCode, loaded.StartedAt, gameLog.StartedAt)
+	}
+	if loaded.Winner != "impostor" || loaded.EndedAt != gameLog.EndedAt {
+		t.Errorf("loaded winner %q ended %d, want impostor ended %d", loaded.Winner, loaded.EndedAt, gameLog.EndedAt)
+	}
+	types := make([]string, 0)
+	for _, event := range loaded.Events {
+		types = append(types, event.Type)
+	}
+	if len(types) != 3 || types[0] != "game-started" || types[2] != "game-ended" {
+		t.Errorf("loaded events %v", types)
+	}
+}
+
+func TestParseGameLogDropsCutOffLine(t *testing.T) {
+	data := `{"id":"a","roomCode":"ABCD","startedAt":1}
+{"offset":0,"timestamp":1,"type":"game-started","data":{"type":"game-started"}}
+{"offset":5,"timestamp":6,"type":"chat-me`
+	gameLog, err := parseGameLog([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gameLog.Events) != 1 {
+		t.Errorf("got %d events, want 1", len(gameLog.Events))
+	}
+
+	if _, err := parseGameLog([]byte("not a header\n")); err == nil {
+		t.Error("parsed a log without a header")
+	}
+}
+
+func TestRecordingStoreRejectsBadIDs(t *testing.T) {
+	store := NewRecordingStore(t.TempDir())
+	for _, id := range []string{"../identity", "", "00000000-0000-0000-0000-000000000000"} {
+		if _, err := store.Load(id); !errors.Is(err, errRecordingNotFound) {
+			t.Errorf("Load(%q): got %v, want %v", id, err, errRecordingNotFound)
+		}
+	}
+}
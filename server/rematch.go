@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+)
+
+// After a game ends, players send play-again to opt in to a rematch. Once
+// every connected player has, the room goes back to the lobby with the
+// same code, settings and host, and keeps a running series score.
+
+var (
+	errRematchNotNow    = errors.New("rematches can only be asked for after a game")
+	errRematchNotPlayer = errors.New("only players can ask for a rematch")
+)
+
+// SeriesScore tallies the rounds a room has played
+type SeriesScore struct {
+	Rounds       int            `json:"rounds"`
+	EngineerWins int            `json:"engineerWins"`
+	ImpostorWins int            `json:"impostorWins"`
+	Wins         map[string]int `json:"wins"` // playerId -> rounds won
+}
+
+// scoreRoundLocked adds a finished game to the series.
+// Caller must hold r.mutex.
+func (r *Room) scoreRoundLocked(winner string) {
+	r.series.Rounds++
+	winningRole := "engineer"
+	if winner == "impostor" {
+		winningRole = "impostor"
+		r.series.ImpostorWins++
+	} else {
+		r.series.EngineerWins++
+	}
+	for _, p := range r.allPlayersLocked() {
+		if p.Role == winningRole {
+			r.series.Wins[p.ID]++
+		}
+	}
+}
+
+// seriesLocked copies the series score for a message.
+// Caller must hold r.mutex.
+func (r *Room) seriesLocked() SeriesScore {
+	series := r.series
+	series.Wins = make(map[string]int, len(r.series.Wins))
+	for id, wins := range r.series.Wins {
+		series.Wins[id] = wins
+	}
+	return series
+}
+
+// PlayAgain opts the client's player in to a rematch
+func (r *Room) PlayAgain(client *Client) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	player := r.players[client]
+	if player == nil {
+		return errRematchNotPlayer
+	}
+	if r.gameState != StateEnded {
+		return errRematchNotNow
+	}
+	r.rematch[player.ID] = true
+	return nil
+}
+
+// CheckRematch goes back to the lobby if every connected player has opted
+// in, and otherwise tells the room who is still missing
+func (r *Room) CheckRematch() {
+	r.mutex.Lock()
+	if r.gameState != StateEnded || len(r.rematch) == 0 || len(r.players) == 0 {
+		r.mutex.Unlock()
+		return
+	}
+
+	ready := make([]string, 0, len(r.players))
+	for _, p := range r.players {
+		if r.rematch[p.ID] {
+			ready = append(ready, p.ID)
+		}
+	}
+	if waiting := len(r.players) - len(ready); waiting > 0 {
+		sort.Strings(ready)
+		r.mutex.Unlock()
+		data, _ := json.Marshal(RematchStatusMessage{
+			Type:    "rematch-status",
+			Ready:   ready,
+			Waiting: waiting,
+		})
+		r.broadcast <- data
+		return
+	}
+
+	r.resetToLobbyLocked()
+	msg := BackToLobbyMessage{
+		Type:     "back-to-lobby",
+		RoomCode: r.code,
+		Settings: r.settings,
+		TaskPool: NewTaskPool(GetTasks(), r.settings.TaskFilter, r.settings.Language, r.playedTasks),
+		Series:   r.seriesLocked(),
+	}
+	r.mutex.Unlock()
+
+	msg.Players = r.GetPlayersPublic()
+	data, _ := json.Marshal(msg)
+	r.broadcast <- data
+	log.Printf("🔄 [LGTM] Room %s is back in the lobby for round %d", r.code, msg.Series.Rounds+1)
+}
+
+// resetToLobbyLocked clears the last game but keeps the players, settings
+// and series. Seats nobody came back for are given up.
+// Caller must hold r.mutex.
+func (r *Room) resetToLobbyLocked() {
+	for id, d := range r.detached {
+		d.expiry.Stop()
+		delete(r.detached, id)
+	}
+	for _, p := range r.players {
+		p.Role = ""
+		p.IsAlive = true
+	}
+	r.passHostLocked()
+
+	r.gameState = StateLobby
+	r.currentTask = nil
+	r.currentCode = ""
+	r.revision = 0
+	r.opLog = make([]RevisionOp, 0)
+	r.snapshotCode = ""
+	r.snapshotRevision = 0
+	r.blame = nil
+	r.editHistory = make([]EditRecord, 0)
+	r.votes = make(map[string]string)
+	r.meetingsUsed = make(map[string]int)
+	r.ghostSolved = make(map[int]bool)
+	r.ghostRunning = make(map[string]bool)
+	r.rematch = make(map[string]bool)
+	r.timeRemaining = r.settings.GameTime
+	r.votingTimeRemaining = r.settings.VotingTime
+}
+
+func (c *Client) handlePlayAgain() {
+	if c.room == nil {
+		return
+	}
+
+	err := c.room.PlayAgain(c)
+	if errors.Is(err, errRematchNotNow) {
+		c.sendError(ErrCodeInvalidState, "You can only ask for a rematch once the game is over!")
+		return
+	}
+	if err != nil {
+		c.sendError(ErrCodeNotAllowed, "Only players can ask for a rematch!")
+		return
+	}
+	c.room.CheckRematch()
+}
@@ -0,0 +1,86 @@
+package main
+
+import "testing"
+
+func TestRematch(t *testing.T) {
+	room, clients := startTestGame(t, newTestHub(t), 4)
+	ghost := clients[1].player(room)
+	room.mutex.Lock()
+	ghost.IsAlive = false
+	room.meetingsUsed[clients[0].id] = 1
+	room.mutex.Unlock()
+
+	clients[0].do("play-again", nil)
+	clients[0].expectError(ErrCodeInvalidState)
+
+	room.EndGame("engineers", EndReasonImpostorsEjected, "Impostor was ejected!")
+	for i, c := range clients[:3] {
+		c.do("play-again", nil)
+		var status RematchStatusMessage
+		clients[3].expect("rematch-status", &status)
+		if status.Waiting != 3-i {
+			t.Errorf("after %d opted in, %d waiting; want %d", i+1, status.Waiting, 3-i)
+		}
+	}
+	clients[3].do("play-again", nil)
+	var lobby BackToLobbyMessage
+	clients[3].expect("back-to-lobby", &lobby)
+	if lobby.RoomCode != room.code || lobby.Series.Rounds != 1 || lobby.Series.EngineerWins != 1 {
+		t.Errorf("back in %s after %d rounds, %d engineer wins; want %s, 1, 1", lobby.RoomCode, lobby.Series.Rounds, lobby.Series.EngineerWins, room.code)
+	}
+
+	room.mutex.RLock()
+	defer room.mutex.RUnlock()
+	if room.gameState != StateLobby || room.currentTask != nil || room.currentCode != "" || room.revision != 0 {
+		t.Errorf("game not reset: state %s, code %q, revision %d", room.gameState, room.currentCode, room.revision)
+	}
+	if len(room.meetingsUsed) != 0 || len(room.rematch) != 0 || len(room.editHistory) != 0 {
+		t.Errorf("round state kept: %d meetings used, %d rematch votes, %d edits", len(room.meetingsUsed), len(room.rematch), len(room.editHistory))
+	}
+	for _, p := range room.players {
+		if p.Role != "" || !p.IsAlive {
+			t.Errorf("%s kept role %q, alive %v", p.ID, p.Role, p.IsAlive)
+		}
+	}
+	if room.hostID != "player-1" || !room.playedTasks[1] {
+		t.Errorf("lost the host %q or played tasks %v", room.hostID, room.playedTasks)
+	}
+}
+
+func TestRematchWaitsOnlyForConnectedPlayers(t *testing.T) {
+	tests := []struct {
+		name  string
+		leave int // players who disconnect after the game
+		ready int // players who opt in
+		lobby bool
+	}{
+		{"nobody left, all in", 0, 4, true},
+		{"nobody left, one missing", 0, 3, false},
+		{"one left, the rest in", 1, 3, true},
+		{"two left, one missing", 2, 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			room, clients := startTestGame(t, newTestHub(t), 4)
+			room.EndGame("impostor", EndReasonTimeUp, "Time ran out!")
+			for _, c := range clients[4-tt.leave:] {
+				room.DetachPlayer(c.Client)
+			}
+			for _, c := range clients[:tt.ready] {
+				if err := room.PlayAgain(c.Client); err != nil {
+					t.Fatal(err)
+				}
+			}
+			room.CheckRematch()
+
+			room.mutex.RLock()
+			defer room.mutex.RUnlock()
+			if lobby := room.gameState == StateLobby; lobby != tt.lobby {
+				t.Errorf("back in the lobby: %v, want %v", lobby, tt.lobby)
+			}
+			if tt.lobby && len(room.detached) != 0 {
+				t.Errorf("%d seats kept for players who left", len(room.detached))
+			}
+		})
+	}
+}
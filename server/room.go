@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"math/rand"
 	"sync"
@@ -10,6 +12,14 @@ import (
 
 type GameState string
 
+// Why a game ended, as reported in game-ended's cause
+const (
+	EndReasonTaskCompleted    = "task_completed"
+	EndReasonTimeUp           = "time_up"
+	EndReasonImpostorsEjected = "impostors_ejected"
+	EndReasonOutnumbered      = "outnumbered"
+)
+
 const (
 	StateLobby   GameState = "lobby"
 	StatePlaying GameState = "playing"
@@ -20,17 +30,44 @@ const (
 type Room struct {
 	code                string
 	hub                 *Hub
+	config              *Config
+	hostID              string
+	joins               int             // players ever added, orders Player.joined
+	kicked              map[string]bool // playerIds the host removed
+	settings            RoomSettings
+	passwordSalt        []byte
+	passwordHash        []byte // set while settings.Visibility is password
 	players             map[*Client]*Player
+	detached            map[string]*detachedPlayer // playerId -> parked seat
+	spectators          map[*Client]bool
 	broadcast           chan []byte
 	gameState           GameState
 	currentTask         *Task
+	startedAt           time.Time
+	playedTasks         map[int]bool // task IDs played this session
 	currentCode         string
+	revision            int
+	opLog               []RevisionOp // ops after snapshotRevision
+	snapshotCode        string
+	snapshotRevision    int
+	blame               []LineBlame // per line of currentCode
+	recording           *GameLog
 	editHistory         []EditRecord
+	chatHistory         []ChatRecord
 	votes               map[string]string // voterId -> targetId
+	meetingsUsed        map[string]int    // playerId -> meetings called this game
+	meetingsAvailableAt time.Time
+	ghostSolved         map[int]bool    // test indexes solved by ghost tasks
+	ghostRunning        map[string]bool // playerId -> ghost task running
+	rematch             map[string]bool // playerIds who sent play-again
+	series              SeriesScore
 	timeRemaining       int
 	votingTimeRemaining int
 	timer               *time.Ticker
 	stopTimer           chan bool
+	done                chan struct{} // closed once the room is deleted
+	closeOnce           sync.Once
+	submitting          bool
 	mutex               sync.RWMutex
 }
 
@@ -40,80 +77,182 @@ type Player struct {
 	Role    string `json:"role"`
 	IsAlive bool   `json:"isAlive"`
 	Color   string `json:"color"`
+	joined  int    // join order; the host passes to the lowest
+	rated   bool   // signed in, so games count towards ratings
 }
 
+// RevisionOp is a committed editor operation. Replaying opLog over
+// snapshotCode rebuilds currentCode.
+type RevisionOp struct {
+	Revision int    `json:"revision"` // revision this op produced
+	PlayerID string `json:"playerId"`
+	Edits    []Edit `json:"ops"`
+	op       *TextOperation
+}
+
+// maxOpLog bounds the op log; older ops are folded into the snapshot
+const maxOpLog = 500
+
+var errStaleRevision = errors.New("revision is no longer available")
+
+var errCodeTooLarge = errors.New("code is over the size limit")
+
+var errNotInLobby = errors.New("the game has already started")
+
+// errGhostEdit keeps ejected players, who know every role, out of the
+// shared code
+var errGhostEdit = errors.New("ghosts can't edit the code")
+
 type EditRecord struct {
-	PlayerID   string `json:"playerId"`
-	PlayerName string `json:"playerName"`
-	Timestamp  int64  `json:"timestamp"`
-	CharDiff   int    `json:"charDiff"`
+	PlayerID     string     `json:"playerId"`
+	PlayerName   string     `json:"playerName"`
+	Timestamp    int64      `json:"timestamp"`
+	CharDiff     int        `json:"charDiff"`
+	LinesAdded   int        `json:"linesAdded"`
+	LinesRemoved int        `json:"linesRemoved"`
+	Hunks        []DiffHunk `json:"hunks"`
 }
 
 func NewRoom(code string, hub *Hub) *Room {
+	settings := DefaultRoomSettings(hub.config)
 	return &Room{
 		code:                code,
 		hub:                 hub,
+		config:              hub.config,
+		settings:            settings,
 		players:             make(map[*Client]*Player),
+		detached:            make(map[string]*detachedPlayer),
+		kicked:              make(map[string]bool),
+		spectators:          make(map[*Client]bool),
+		playedTasks:         make(map[int]bool),
 		broadcast:           make(chan []byte, 256),
 		gameState:           StateLobby,
 		editHistory:         make([]EditRecord, 0),
+		chatHistory:         make([]ChatRecord, 0),
 		votes:               make(map[string]string),
-		timeRemaining:       180,
-		votingTimeRemaining: 60,
+		meetingsUsed:        make(map[string]int),
+		ghostSolved:         make(map[int]bool),
+		ghostRunning:        make(map[string]bool),
+		rematch:             make(map[string]bool),
+		series:              SeriesScore{Wins: make(map[string]int)},
+		timeRemaining:       settings.GameTime,
+		votingTimeRemaining: settings.VotingTime,
 		stopTimer:           make(chan bool),
+		done:                make(chan struct{}),
 	}
 }
 
 func (r *Room) Run() {
 	for {
 		select {
+		case <-r.done:
+			return
+
 		case message := <-r.broadcast:
 			r.mutex.Lock()
+			r.recordLocked(message)
 			// Collect clients that need to be removed
 			clientsToRemove := make([]*Client, 0)
 			for client := range r.players {
-				select {
-				case client.send <- message:
-					// Successfully sent
-				default:
+				// trySend checks for a client its writePump already closed
+				if !client.trySend(message) {
 					// Channel full or closed - mark for removal
 					// Don't close channel here - it will be closed by hub.unregister
 					clientsToRemove = append(clientsToRemove, client)
 				}
 			}
+			spectatorsToRemove := make([]*Client, 0)
+			for client := range r.spectators {
+				if !client.trySend(message) {
+					spectatorsToRemove = append(spectatorsToRemove, client)
+				}
+			}
 			// Remove dead clients outside the loop to avoid modifying map while iterating
 			for _, client := range clientsToRemove {
-				// Just remove from room - hub will handle channel closing
-				delete(r.players, client)
-				client.room = nil
-				// Signal hub to clean up (non-blocking)
-				select {
-				case client.hub.unregister <- client:
-					// Successfully queued for cleanup
-				default:
-					// Hub busy, but client already removed from room
-					// Channel will be closed when hub processes it
-				}
+				r.dropClientLocked(client)
+			}
+			for _, client := range spectatorsToRemove {
+				r.dropSpectatorLocked(client)
+			}
+			if len(clientsToRemove) > 0 {
+				r.hub.metrics.broadcastsDropped.Add(float64(len(clientsToRemove)), "player")
+				// The hub won't see these as leaving the room, so do
+				// what it would; not from Run, which drains broadcast
+				go func() {
+					if r.IsEmpty() {
+						r.hub.DeleteRoom(r.code)
+						r.Close()
+						return
+					}
+					r.CheckRematch()
+					r.BroadcastPlayerList()
+				}()
+			}
+			if len(spectatorsToRemove) > 0 {
+				r.hub.metrics.broadcastsDropped.Add(float64(len(spectatorsToRemove)), "spectator")
 			}
 			r.mutex.Unlock()
 		}
 	}
 }
 
+// Close stops the room's goroutines and sends its spectators away once
+// the hub has deleted it
+func (r *Room) Close() {
+	r.closeOnce.Do(func() {
+		close(r.done)
+		r.CloseSpectators()
+		// A game abandoned before its result keeps what was recorded
+		r.mutex.Lock()
+		if r.recording != nil {
+			r.hub.recordings.Finish(r.recording)
+			r.recording = nil
+		}
+		r.mutex.Unlock()
+	})
+}
+
+// dropClientLocked removes a client that can't keep up and asks the hub
+// to clean it up. Caller must hold r.mutex.
+func (r *Room) dropClientLocked(client *Client) {
+	// Just remove from room - hub will handle channel closing.
+	// Mid-game the seat is parked so the player can resume.
+	r.detachLocked(client)
+	// Signal hub to clean up (non-blocking)
+	select {
+	case client.hub.unregister <- client:
+		// Successfully queued for cleanup
+	default:
+		// Hub busy, but client already removed from room
+		// Channel will be closed when hub processes it
+	}
+}
+
 func (r *Room) AddPlayer(client *Client, name string) *Player {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
-	colors := []string{"#00ff88", "#ff6b6b", "#4ecdc4", "#ffe66d"}
+	colors := []string{
+		"#00ff88", "#ff6b6b", "#4ecdc4", "#ffe66d", "#a78bfa",
+		"#f472b6", "#60a5fa", "#fb923c", "#94a3b8", "#facc15",
+	}
+	r.joins++
 	player := &Player{
 		ID:      client.id,
 		Name:    name,
 		Role:    "",
 		IsAlive: true,
 		Color:   colors[len(r.players)%len(colors)],
+		joined:  r.joins,
+		rated:   client.profile != nil,
 	}
 	r.players[client] = player
 	client.room = r
+	client.StopReplay()
+	// The room's creator is its first host
+	if r.hostID == "" {
+		r.hostID = player.ID
+	}
 	return player
 }
 
@@ -122,6 +261,7 @@ func (r *Room) RemovePlayer(client *Client) {
 	defer r.mutex.Unlock()
 	delete(r.players, client)
 	client.room = nil
+	r.passHostLocked()
 }
 
 func (r *Room) GetPlayers() []*Player {
@@ -135,81 +275,201 @@ func (r *Room) GetPlayers() []*Player {
 	return players
 }
 
-func (r *Room) GetPlayersPublic() []map[string]interface{} {
+func (r *Room) GetPlayersPublic() []PublicPlayer {
 	r.mutex.RLock()
 	defer r.mutex.RUnlock()
 
-	players := make([]map[string]interface{}, 0, len(r.players))
+	players := make([]PublicPlayer, 0, len(r.players)+len(r.detached))
 	for _, p := range r.players {
-		players = append(players, map[string]interface{}{
-			"id":      p.ID,
-			"name":    p.Name,
-			"isAlive": p.IsAlive,
-			"color":   p.Color,
-		})
+		players = append(players, r.publicPlayerLocked(p, true))
+	}
+	for _, d := range r.detached {
+		players = append(players, r.publicPlayerLocked(d.player, false))
 	}
 	return players
 }
 
+// publicPlayerLocked is the player as others see them, without the role.
+// Caller must hold r.mutex.
+func (r *Room) publicPlayerLocked(p *Player, connected bool) PublicPlayer {
+	return PublicPlayer{
+		ID:           p.ID,
+		Name:         p.Name,
+		IsAlive:      p.IsAlive,
+		Color:        p.Color,
+		Connected:    connected,
+		IsHost:       p.ID == r.hostID,
+		MeetingsLeft: r.meetingsLeftLocked(p),
+	}
+}
+
 func (r *Room) BroadcastPlayerList() {
 	players := r.GetPlayersPublic()
-	msg := map[string]interface{}{
-		"type":    "player-list",
-		"players": players,
+	r.mutex.RLock()
+	spectators := len(r.spectators)
+	r.mutex.RUnlock()
+
+	msg := PlayerListMessage{
+		Type:       "player-list",
+		Players:    players,
+		Spectators: spectators,
 	}
 	data, _ := json.Marshal(msg)
 	r.broadcast <- data
 }
 
-func (r *Room) StartGame() {
+// StartGame assigns roles and picks a task in the room's language. It
+// fails with errNotInLobby unless the room is waiting in the lobby.
+func (r *Room) StartGame() error {
 	r.mutex.Lock()
+	if r.gameState != StateLobby {
+		r.mutex.Unlock()
+		return errNotInLobby
+	}
 
-	// Assign roles - 1 impostor, rest engineers
+	// Select a random task the room hasn't played yet
+	picked, err := SelectTask(GetTasks(), r.settings.TaskFilter, r.settings.Language, r.playedTasks)
+	if err != nil {
+		r.mutex.Unlock()
+		log.Printf("[LGTM] No task for room %s: %v", r.code, err)
+		return err
+	}
+	language := r.settings.Language
+	if language == "" {
+		language = picked.PrimaryLanguage()
+	}
+	editorMode := Languages[language].EditorMode
+
+	// Assign roles - settings.ImpostorCount impostors, rest engineers
 	playerList := make([]*Player, 0, len(r.players))
 	for _, p := range r.players {
 		playerList = append(playerList, p)
 	}
 
 	rand.Seed(time.Now().UnixNano())
-	impostorIndex := rand.Intn(len(playerList))
+	rand.Shuffle(len(playerList), func(i, j int) {
+		playerList[i], playerList[j] = playerList[j], playerList[i]
+	})
 
 	for i, p := range playerList {
-		if i == impostorIndex {
+		if i < r.settings.ImpostorCount {
 			p.Role = "impostor"
 		} else {
 			p.Role = "engineer"
 		}
 	}
 
-	// Select random task
-	tasks := GetTasks()
-	if len(tasks) == 0 {
-		log.Printf("[LGTM] No tasks available!")
-		return
-	}
-	r.currentTask = &tasks[rand.Intn(len(tasks))]
+	r.currentTask = picked.ForLanguage(language)
+	r.playedTasks[picked.ID] = true
 	r.currentCode = r.currentTask.StarterCode
+	r.revision = 0
+	r.opLog = make([]RevisionOp, 0)
+	r.snapshotCode = r.currentCode
+	r.snapshotRevision = 0
+	r.blame = newBlame(r.currentCode)
 	r.gameState = StatePlaying
-	r.timeRemaining = 180
+	r.startedAt = time.Now()
+	timeLimit := r.settings.GameTime
+	if picked.TimeLimit > 0 {
+		timeLimit = picked.TimeLimit
+	}
+	r.timeRemaining = timeLimit
 	r.editHistory = make([]EditRecord, 0)
+	r.chatHistory = make([]ChatRecord, 0)
+	r.meetingsUsed = make(map[string]int)
+	r.ghostSolved = make(map[int]bool)
+	r.ghostRunning = make(map[string]bool)
+	r.startMeetingCooldownLocked()
+	meetingCooldown := r.settings.MeetingCooldown
+
+	// Start a fresh log with the roster and role assignment
+	r.recording = r.hub.recordings.Create(r.code)
+	gameID := r.recording.ID
+	roster := make([]PublicPlayer, 0, len(playerList))
+	for _, p := range playerList {
+		entry := r.publicPlayerLocked(p, true)
+		entry.Role = p.Role
+		roster = append(roster, entry)
+	}
+	settings := r.settings
+	started, _ := json.Marshal(GameStartedMessage{
+		Type:       "game-started",
+		GameID:     gameID,
+		Task:       r.currentTask,
+		Language:   language,
+		EditorMode: editorMode,
+		TimeLimit:  timeLimit,
+		Settings:   &settings,
+		Players:    roster,
+
+		MeetingCooldown: meetingCooldown,
+	})
+	r.recordLocked(started)
 
 	r.mutex.Unlock()
 
 	// Send game started to each player with their role
 	for client, player := range r.players {
-		msg := map[string]interface{}{
-			"type":      "game-started",
-			"role":      player.Role,
-			"task":      r.currentTask,
-			"timeLimit": 180,
-			"players":   r.GetPlayersPublic(),
+		msg := GameStartedMessage{
+			Type:       "game-started",
+			GameID:     gameID,
+			Role:       player.Role,
+			Task:       r.currentTask,
+			Language:   language,
+			EditorMode: editorMode,
+			TimeLimit:  timeLimit,
+			Players:    r.GetPlayersPublic(),
+
+			MeetingCooldown: meetingCooldown,
+		}
+		if player.Role == "impostor" {
+			msg.Teammates = r.ImpostorTeammates(player)
 		}
 		data, _ := json.Marshal(msg)
 		client.send <- data
 	}
 
+	// Spectators get the same start without a role
+	spectatorMsg, _ := json.Marshal(GameStartedMessage{
+		Type:       "game-started",
+		GameID:     gameID,
+		Task:       r.currentTask,
+		Language:   language,
+		EditorMode: editorMode,
+		TimeLimit:  timeLimit,
+		Players:    r.GetPlayersPublic(),
+		Spectator:  true,
+
+		MeetingCooldown: meetingCooldown,
+	})
+	r.SendToSpectators(spectatorMsg)
+
+	r.hub.metrics.gamesStarted.Add(1)
+
 	// Start game timer
 	go r.StartGameTimer()
+	return nil
+}
+
+// ImpostorTeammates lists the other impostors, for impostor eyes only
+func (r *Room) ImpostorTeammates(player *Player) []PlayerRef {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.impostorTeammatesLocked(player)
+}
+
+func (r *Room) impostorTeammatesLocked(player *Player) []PlayerRef {
+	teammates := make([]PlayerRef, 0)
+	for _, p := range r.allPlayersLocked() {
+		if p.Role == "impostor" && p.ID != player.ID {
+			teammates = append(teammates, PlayerRef{
+				ID:    p.ID,
+				Name:  p.Name,
+				Color: p.Color,
+			})
+		}
+	}
+	return teammates
 }
 
 func (r *Room) StartGameTimer() {
@@ -229,77 +489,324 @@ func (r *Room) StartGameTimer() {
 				return
 			}
 
-			msg := map[string]interface{}{
-				"type":          "time-update",
-				"timeRemaining": timeLeft,
+			msg := TimeUpdateMessage{
+				Type:          "time-update",
+				TimeRemaining: timeLeft,
 			}
 			data, _ := json.Marshal(msg)
 			r.broadcast <- data
 
 			if timeLeft <= 0 {
-				r.EndGame("impostor", "Time ran out!")
+				r.EndGame("impostor", EndReasonTimeUp, "Time ran out!")
 				return
 			}
 
 		case <-r.stopTimer:
 			return
+
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// ApplyOperation transforms edits made against baseRevision over every
+// op committed since, then applies them to the shared code
+func (r *Room) ApplyOperation(client *Client, baseRevision int, edits []Edit) error {
+	r.mutex.Lock()
+	player := r.players[client]
+	if player == nil {
+		r.mutex.Unlock()
+		return nil
+	}
+	if !player.IsAlive {
+		r.mutex.Unlock()
+		return errGhostEdit
+	}
+	if baseRevision < r.snapshotRevision || baseRevision > r.revision {
+		r.mutex.Unlock()
+		return errStaleRevision
+	}
+
+	concurrent := r.opLog[baseRevision-r.snapshotRevision:]
+	baseLen := len(u16(r.currentCode))
+	if len(concurrent) > 0 {
+		baseLen = concurrent[0].op.baseLen
+	}
+
+	op, err := OperationFromEdits(baseLen, edits)
+	if err != nil {
+		r.mutex.Unlock()
+		return err
+	}
+	for _, logged := range concurrent {
+		if op, _, err = Transform(op, logged.op); err != nil {
+			r.mutex.Unlock()
+			return err
 		}
 	}
+	if op.IsNoop() {
+		// Nothing to commit, so no new revision: the ack carries the one
+		// the author is now at
+		ack, _ := json.Marshal(CodeAckMessage{
+			Type:     "code-ack",
+			Revision: r.revision,
+		})
+		r.mutex.Unlock()
+		client.trySend(ack)
+		return nil
+	}
+
+	updated, err := r.commitLocked(client, player, op)
+	r.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	r.broadcast <- updated
+	return nil
 }
 
-func (r *Room) UpdateCode(client *Client, code string) {
+// UpdateCode handles the legacy whole-buffer update by diffing it into an
+// operation against the latest revision
+func (r *Room) UpdateCode(client *Client, code string) error {
 	r.mutex.Lock()
 	player := r.players[client]
-	oldLen := len(r.currentCode)
+	if player == nil {
+		r.mutex.Unlock()
+		return nil
+	}
+	if !player.IsAlive {
+		r.mutex.Unlock()
+		return errGhostEdit
+	}
+	op := OperationFromDiff(r.currentCode, code)
+	if op.IsNoop() {
+		r.mutex.Unlock()
+		return nil
+	}
+	updated, err := r.commitLocked(client, player, op)
+	r.mutex.Unlock()
+	if err != nil {
+		log.Printf("[LGTM] Failed to apply code update in room %s: %v", r.code, err)
+		return err
+	}
+
+	r.broadcast <- updated
+	return nil
+}
+
+// commitLocked applies op as the next revision, acks the author and sends
+// the op to everyone else in revision order. It returns the legacy
+// whole-buffer code-updated message for the caller to broadcast.
+// Caller must hold r.mutex.
+func (r *Room) commitLocked(author *Client, player *Player, op *TextOperation) ([]byte, error) {
+	oldCode := r.currentCode
+	code, err := op.Apply(oldCode)
+	if err != nil {
+		return nil, err
+	}
+	if len(code) > r.config.MaxCodeSize && len(code) > len(oldCode) {
+		return nil, errCodeTooLarge
+	}
+
 	r.currentCode = code
+	r.revision++
+	edits := op.Edits()
+	r.opLog = append(r.opLog, RevisionOp{
+		Revision: r.revision,
+		PlayerID: player.ID,
+		Edits:    edits,
+		op:       op,
+	})
 
-	r.editHistory = append(r.editHistory, EditRecord{
+	// Fold the oldest half of the log into the snapshot
+	if len(r.opLog) > maxOpLog {
+		folded := len(r.opLog) / 2
+		for _, logged := range r.opLog[:folded] {
+			r.snapshotCode, _ = logged.op.Apply(r.snapshotCode)
+		}
+		r.snapshotRevision += folded
+		r.opLog = append([]RevisionOp{}, r.opLog[folded:]...)
+	}
+
+	hunks := DiffLines(oldCode, code)
+	linesAdded, linesRemoved := 0, 0
+	for _, h := range hunks {
+		linesAdded += h.NewLines
+		linesRemoved += h.OldLines
+	}
+	now := time.Now().UnixMilli()
+	r.blame = applyBlame(r.blame, hunks, LineBlame{
 		PlayerID:   player.ID,
 		PlayerName: player.Name,
-		Timestamp:  time.Now().UnixMilli(),
-		CharDiff:   len(code) - oldLen,
+		Timestamp:  now,
+	})
+	r.editHistory = append(r.editHistory, EditRecord{
+		PlayerID:     player.ID,
+		PlayerName:   player.Name,
+		Timestamp:    now,
+		CharDiff:     len(code) - len(oldCode),
+		LinesAdded:   linesAdded,
+		LinesRemoved: linesRemoved,
+		Hunks:        hunks,
 	})
 
 	// Keep only last 50 edits
 	if len(r.editHistory) > 50 {
 		r.editHistory = r.editHistory[len(r.editHistory)-50:]
 	}
-	r.mutex.Unlock()
 
-	msg := map[string]interface{}{
-		"type":         "code-updated",
-		"code":         code,
-		"lastEditor":   player.Name,
-		"lastEditorId": player.ID,
+	// Acks and ops skip the broadcast channel so every client sees
+	// revisions in order relative to its own pending op
+	ack, _ := json.Marshal(CodeAckMessage{
+		Type:     "code-ack",
+		Revision: r.revision,
+	})
+	remote, _ := json.Marshal(CodeOpMessage{
+		Type:       "code-op",
+		Revision:   r.revision,
+		Ops:        edits,
+		PlayerID:   player.ID,
+		PlayerName: player.Name,
+	})
+	lagging := make([]*Client, 0)
+	for client := range r.players {
+		data := remote
+		if client == author {
+			data = ack
+		}
+		if !client.trySend(data) {
+			lagging = append(lagging, client)
+		}
+	}
+	for _, client := range lagging {
+		r.dropClientLocked(client)
+	}
+
+	updated, _ := json.Marshal(CodeUpdatedMessage{
+		Type:         "code-updated",
+		Code:         code,
+		Revision:     r.revision,
+		LastEditor:   player.Name,
+		LastEditorID: player.ID,
+	})
+	return updated, nil
+}
+
+// CodeSnapshot lets a late joiner rebuild the document: apply ops in
+// order on top of code to reach currentRevision
+func (r *Room) CodeSnapshot() CodeSnapshotMessage {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	ops := make([]RevisionOp, len(r.opLog))
+	copy(ops, r.opLog)
+	return CodeSnapshotMessage{
+		Type:            "code-snapshot",
+		Revision:        r.snapshotRevision,
+		Code:            r.snapshotCode,
+		Ops:             ops,
+		CurrentRevision: r.revision,
+	}
+}
+
+// VerifySubmission runs the room's code against the task's test cases on
+// the server and ends the game only if every test passes.
+func (r *Room) VerifySubmission(submitter *Client, task *Task, code string) {
+	defer func() {
+		r.mutex.Lock()
+		r.submitting = false
+		r.mutex.Unlock()
+	}()
+
+	result, err := r.hub.runner.Run(context.Background(), task, task.Language, code)
+	if err != nil {
+		log.Printf("[LGTM] Test runner error in room %s: %v", r.code, err)
+		result = &TestRunResult{Passed: false, Results: []TestResult{}, Error: "Test runner unavailable, try again."}
+	}
+
+	r.mutex.RLock()
+	submitterPlayer := r.players[submitter]
+	stale := r.gameState != StatePlaying || r.currentTask != task
+	r.mutex.RUnlock()
+
+	// The game ended, or a rematch started, while the tests ran
+	if stale {
+		return
+	}
+
+	submittedBy := ""
+	if submitterPlayer != nil {
+		submittedBy = submitterPlayer.Name
+	}
+
+	msg := TaskResultMessage{
+		Type:        "task-result",
+		Passed:      result.Passed,
+		Results:     result.Results,
+		Error:       result.Error,
+		SubmittedBy: submittedBy,
 	}
 	data, _ := json.Marshal(msg)
 	r.broadcast <- data
+
+	if result.Passed {
+		r.EndGame("engineers", EndReasonTaskCompleted, "Task completed successfully! All tests passed! 🎉")
+		log.Printf("✅ [LGTM] Task submitted successfully in room: %s", r.code)
+		return
+	}
+
+	// Kept for clients that only understand the old failure message
+	failed := NoticeMessage{
+		Type:    "task-failed",
+		Message: "Tests failed! Fix the code and try again.",
+	}
+	if submitterPlayer != nil {
+		r.SendToClient(submitter, failed)
+	}
+	log.Printf("❌ [LGTM] Task submission failed in room: %s", r.code)
 }
 
-func (r *Room) CallMeeting(caller *Client) {
+// CallMeeting starts a vote if the caller may call one now; otherwise it
+// returns why not
+func (r *Room) CallMeeting(caller *Client) error {
 	r.mutex.Lock()
+	callerPlayer := r.players[caller]
+	if err := r.checkMeetingLocked(callerPlayer); err != nil {
+		r.mutex.Unlock()
+		return err
+	}
+	r.meetingsUsed[callerPlayer.ID]++
 	r.gameState = StateVoting
 	r.votes = make(map[string]string)
-	r.votingTimeRemaining = 60
-	callerPlayer := r.players[caller]
+	r.votingTimeRemaining = r.settings.VotingTime
+	votingTime := r.settings.VotingTime
+	editHistory := make([]EditRecord, len(r.editHistory))
+	copy(editHistory, r.editHistory)
 	r.mutex.Unlock()
 
+	r.hub.metrics.meetings.Add(1)
+
 	// Stop game timer
 	select {
 	case r.stopTimer <- true:
 	default:
 	}
 
-	msg := map[string]interface{}{
-		"type":        "meeting-called",
-		"caller":      callerPlayer.Name,
-		"editHistory": r.editHistory,
-		"players":     r.GetPlayersPublic(),
+	msg := MeetingCalledMessage{
+		Type:        "meeting-called",
+		Caller:      callerPlayer.Name,
+		CallerID:    callerPlayer.ID,
+		EditHistory: editHistory,
+		Players:     r.GetPlayersPublic(),
+		VotingTime:  votingTime,
 	}
 	data, _ := json.Marshal(msg)
 	r.broadcast <- data
 
 	go r.StartVotingTimer()
+	return nil
 }
 
 func (r *Room) StartVotingTimer() {
@@ -319,9 +826,9 @@ func (r *Room) StartVotingTimer() {
 				return
 			}
 
-			msg := map[string]interface{}{
-				"type":          "voting-time-update",
-				"timeRemaining": timeLeft,
+			msg := TimeUpdateMessage{
+				Type:          "voting-time-update",
+				TimeRemaining: timeLeft,
 			}
 			data, _ := json.Marshal(msg)
 			r.broadcast <- data
@@ -330,6 +837,9 @@ func (r *Room) StartVotingTimer() {
 				r.TallyVotes()
 				return
 			}
+
+		case <-r.done:
+			return
 		}
 	}
 }
@@ -344,18 +854,18 @@ func (r *Room) CastVote(voter *Client, targetID string) {
 	// Count votes
 	voteCount := len(r.votes)
 	aliveCount := 0
-	for _, p := range r.players {
+	for _, p := range r.allPlayersLocked() {
 		if p.IsAlive {
 			aliveCount++
 		}
 	}
 	r.mutex.Unlock()
 
-	msg := map[string]interface{}{
-		"type": "vote-cast",
-		"votesCount": map[string]int{
-			"voted": voteCount,
-			"total": aliveCount,
+	msg := VoteCastMessage{
+		Type: "vote-cast",
+		VotesCount: VoteCount{
+			Voted: voteCount,
+			Total: aliveCount,
 		},
 	}
 	data, _ := json.Marshal(msg)
@@ -389,7 +899,7 @@ func (r *Room) TallyVotes() {
 
 	// Need majority to eject
 	aliveCount := 0
-	for _, p := range r.players {
+	for _, p := range r.allPlayersLocked() {
 		if p.IsAlive {
 			aliveCount++
 		}
@@ -398,9 +908,10 @@ func (r *Room) TallyVotes() {
 
 	var ejectedPlayer *Player
 	wasImpostor := false
+	impostorsRemaining := 0
 
 	if ejectedID != "" && maxVotes >= majorityNeeded {
-		for _, p := range r.players {
+		for _, p := range r.allPlayersLocked() {
 			if p.ID == ejectedID {
 				p.IsAlive = false
 				ejectedPlayer = p
@@ -410,44 +921,61 @@ func (r *Room) TallyVotes() {
 		}
 	}
 
+	for _, p := range r.allPlayersLocked() {
+		if p.IsAlive && p.Role == "impostor" {
+			impostorsRemaining++
+		}
+	}
+
 	r.mutex.Unlock()
 
 	// Send voting result
-	var ejectedData interface{}
+	var ejectedData *PlayerRef
 	if ejectedPlayer != nil {
-		ejectedData = map[string]string{
-			"id":   ejectedPlayer.ID,
-			"name": ejectedPlayer.Name,
+		ejectedData = &PlayerRef{
+			ID:   ejectedPlayer.ID,
+			Name: ejectedPlayer.Name,
 		}
 	}
 
-	msg := map[string]interface{}{
-		"type":          "voting-ended",
-		"ejectedPlayer": ejectedData,
-		"wasImpostor":   wasImpostor,
-		"votes":         r.votes,
+	msg := VotingEndedMessage{
+		Type:               "voting-ended",
+		EjectedPlayer:      ejectedData,
+		WasImpostor:        wasImpostor,
+		ImpostorsRemaining: impostorsRemaining,
+		Votes:              r.votes,
 	}
 	data, _ := json.Marshal(msg)
 	r.broadcast <- data
 
+	if ejectedPlayer != nil {
+		r.sendGhostMode(ejectedPlayer)
+	}
+
 	// Check win condition
-	time.Sleep(3 * time.Second)
+	time.Sleep(r.config.ResultsDelay.D())
 
-	if winner, reason := r.CheckWinCondition(); winner != "" {
-		r.EndGame(winner, reason)
+	if winner, cause, reason := r.CheckWinCondition(); winner != "" {
+		r.EndGame(winner, cause, reason)
 	} else {
 		r.ResumeGame()
 	}
 }
 
-func (r *Room) CheckWinCondition() (string, string) {
+// CheckWinCondition returns the winner, the cause and a message, or
+// empty strings while the game goes on
+func (r *Room) CheckWinCondition() (string, string, string) {
 	r.mutex.RLock()
 	defer r.mutex.RUnlock()
 
+	totalImpostors := 0
 	aliveImpostors := 0
 	aliveEngineers := 0
 
-	for _, p := range r.players {
+	for _, p := range r.allPlayersLocked() {
+		if p.Role == "impostor" {
+			totalImpostors++
+		}
 		if p.IsAlive {
 			if p.Role == "impostor" {
 				aliveImpostors++
@@ -458,25 +986,34 @@ func (r *Room) CheckWinCondition() (string, string) {
 	}
 
 	if aliveImpostors == 0 {
-		return "engineers", "Impostor was ejected!"
+		if totalImpostors > 1 {
+			return "engineers", EndReasonImpostorsEjected, "All impostors were ejected!"
+		}
+		return "engineers", EndReasonImpostorsEjected, "Impostor was ejected!"
 	}
 
 	if aliveImpostors >= aliveEngineers {
-		return "impostor", "Impostor outnumbers engineers!"
+		if totalImpostors > 1 {
+			return "impostor", EndReasonOutnumbered, "Impostors outnumber engineers!"
+		}
+		return "impostor", EndReasonOutnumbered, "Impostor outnumbers engineers!"
 	}
 
-	return "", ""
+	return "", "", ""
 }
 
 func (r *Room) ResumeGame() {
 	r.mutex.Lock()
 	r.gameState = StatePlaying
+	r.startMeetingCooldownLocked()
+	meetingCooldown := r.settings.MeetingCooldown
 	r.mutex.Unlock()
 
-	msg := map[string]interface{}{
-		"type":          "game-resumed",
-		"players":       r.GetPlayersPublic(),
-		"timeRemaining": r.timeRemaining,
+	msg := GameResumedMessage{
+		Type:            "game-resumed",
+		Players:         r.GetPlayersPublic(),
+		TimeRemaining:   r.timeRemaining,
+		MeetingCooldown: meetingCooldown,
 	}
 	data, _ := json.Marshal(msg)
 	r.broadcast <- data
@@ -484,49 +1021,69 @@ func (r *Room) ResumeGame() {
 	go r.StartGameTimer()
 }
 
-func (r *Room) EndGame(winner, reason string) {
+func (r *Room) EndGame(winner, cause, reason string) {
 	r.mutex.Lock()
 	r.gameState = StateEnded
 
+	gameID := ""
+	if r.recording != nil {
+		gameID = r.recording.ID
+	}
+
+	// startedAt is cleared so a game is only counted once
+	if !r.startedAt.IsZero() {
+		r.hub.metrics.gamesFinished.Add(1, winner, cause)
+		r.hub.metrics.gameDuration.Observe(time.Since(r.startedAt).Seconds())
+		r.scoreRoundLocked(winner)
+		if match, ok := r.matchRecordLocked(gameID, winner, cause); ok {
+			r.hub.ratings.RecordGameAsync(match)
+		}
+		r.startedAt = time.Time{}
+	}
+
 	// Stop timer
 	select {
 	case r.stopTimer <- true:
 	default:
 	}
 
-	// Get impostor
-	var impostor map[string]string
-	playersWithRoles := make([]map[string]interface{}, 0)
-
-	for _, p := range r.players {
+	// Get impostors; "impostor" keeps the first one for older clients
+	var impostor *PlayerRef
+	impostors := make([]PlayerRef, 0)
+	for _, p := range r.allPlayersLocked() {
 		if p.Role == "impostor" {
-			impostor = map[string]string{
-				"id":   p.ID,
-				"name": p.Name,
+			entry := PlayerRef{
+				ID:   p.ID,
+				Name: p.Name,
 			}
+			if impostor == nil {
+				impostor = &entry
+			}
+			impostors = append(impostors, entry)
 		}
-		playersWithRoles = append(playersWithRoles, map[string]interface{}{
-			"id":      p.ID,
-			"name":    p.Name,
-			"role":    p.Role,
-			"isAlive": p.IsAlive,
-			"color":   p.Color,
-		})
 	}
+	playersWithRoles := r.rolesLocked()
+	blame := r.blameLocked()
+	series := r.seriesLocked()
 	r.mutex.Unlock()
 
-	msg := map[string]interface{}{
-		"type":     "game-ended",
-		"winner":   winner,
-		"reason":   reason,
-		"impostor": impostor,
-		"players":  playersWithRoles,
+	msg := GameEndedMessage{
+		Type:      "game-ended",
+		GameID:    gameID,
+		Winner:    winner,
+		Cause:     cause,
+		Reason:    reason,
+		Impostor:  impostor,
+		Impostors: impostors,
+		Players:   playersWithRoles,
+		Blame:     blame,
+		Series:    series,
 	}
 	data, _ := json.Marshal(msg)
 	r.broadcast <- data
 }
 
-func (r *Room) SendToClient(client *Client, msg map[string]interface{}) {
+func (r *Room) SendToClient(client *Client, msg interface{}) {
 	data, _ := json.Marshal(msg)
 	client.send <- data
 }
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	runnerMaxOutput = 1 << 20
+	// runnerWaitDelay bounds how long a killed run may hold its output
+	// open before Wait gives up on it
+	runnerWaitDelay = 500 * time.Millisecond
+)
+
+// TestRunner executes a task's test cases against submitted code.
+// Implementations must treat the code as untrusted.
+type TestRunner interface {
+	Run(ctx context.Context, task *Task, language, code string) (*TestRunResult, error)
+}
+
+type TestResult struct {
+	Input         interface{} `json:"input"`
+	Expected      interface{} `json:"expected"`
+	Actual        interface{} `json:"actual"`
+	Passed        bool        `json:"passed"`
+	Error         string      `json:"error,omitempty"`
+	ExecutionTime float64     `json:"executionTime,omitempty"`
+}
+
+type TestRunResult struct {
+	Passed  bool         `json:"passed"`
+	Results []TestResult `json:"results"`
+	Error   string       `json:"error,omitempty"`
+}
+
+// harnessInput is written to the harness on stdin. The submitted code
+// sits next to the harness in the language's solution file.
+type harnessInput struct {
+	FunctionName  string     `json:"functionName"`
+	TestCases     []TestCase `json:"testCases"`
+	TestTimeoutMs int64      `json:"testTimeoutMs"`
+	MemoryLimitMB int        `json:"memoryLimitMB"`
+}
+
+// harnessOutput is what every harness prints on stdout. Harnesses only
+// report what the function returned; comparing against the expected
+// values happens here so every language is judged the same way.
+type harnessOutput struct {
+	Error   string `json:"error"`
+	Results []struct {
+		Actual        interface{} `json:"actual"`
+		Error         string      `json:"error"`
+		TimedOut      bool        `json:"timedOut"`
+		ExecutionTime float64     `json:"executionTime"`
+	} `json:"results"`
+}
+
+// SubprocessRunner runs each submission in a fresh subprocess with CPU,
+// memory and wall-clock limits, using the harness for its language.
+// With Sandbox set, submissions run as UID:GID, and with Namespaces in
+// their own namespaces too (see sandbox); the run directory is read-only
+// to them.
+type SubprocessRunner struct {
+	Languages    map[string]*Language
+	Timeout      time.Duration
+	BuildTimeout time.Duration
+	TestTimeout  time.Duration
+	CPUSeconds   int
+	MemoryMB     int
+	Sandbox      bool
+	Namespaces   bool
+	UID          int
+	GID          int
+}
+
+func NewSubprocessRunner(config *Config) *SubprocessRunner {
+	return &SubprocessRunner{
+		Languages:    Languages,
+		Timeout:      config.RunnerTimeout.D(),
+		BuildTimeout: config.RunnerBuildTimeout.D(),
+		TestTimeout:  config.RunnerTestTimeout.D(),
+		CPUSeconds:   config.RunnerCPUSeconds,
+		MemoryMB:     config.RunnerMemoryMB,
+		Sandbox:      config.RunnerSandbox,
+		Namespaces:   config.RunnerNamespaces,
+		UID:          config.RunnerUID,
+		GID:          config.RunnerGID,
+	}
+}
+
+func (s *SubprocessRunner) Run(ctx context.Context, task *Task, language, code string) (*TestRunResult, error) {
+	lang := s.Languages[language]
+	if lang == nil {
+		return nil, fmt.Errorf("unsupported language %q", language)
+	}
+
+	input, err := json.Marshal(harnessInput{
+		FunctionName:  task.FunctionName,
+		TestCases:     task.TestCases,
+		TestTimeoutMs: s.TestTimeout.Milliseconds(),
+		MemoryLimitMB: s.MemoryMB,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := s.makeRunDir()
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	harness := strings.ReplaceAll(lang.Harness, "FUNCTION_NAME", task.FunctionName)
+	if err := os.WriteFile(filepath.Join(dir, lang.HarnessFile), []byte(harness), 0o644); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, lang.SolutionFile), []byte(code), 0o644); err != nil {
+		return nil, err
+	}
+
+	if lang.Build != nil {
+		buildCtx, cancel := context.WithTimeout(ctx, s.BuildTimeout)
+		defer cancel()
+
+		argv := lang.Build(dir)
+		cmd := exec.CommandContext(buildCtx, argv[0], argv[1:]...)
+		cmd.Dir = dir
+		cmd.Env = append(s.baseEnv(), lang.Env(dir)...)
+		// Compiling doesn't execute the code, so the build keeps the
+		// server's user and with it a build cache submissions can't touch
+		s.sandbox(cmd, false)
+		cmd.WaitDelay = runnerWaitDelay
+		output := &limitedBuffer{max: runnerMaxOutput}
+		cmd.Stdout = output
+		cmd.Stderr = output
+		err := cmd.Run()
+		killGroup(cmd)
+		if err != nil {
+			if buildCtx.Err() == context.DeadlineExceeded {
+				return failAll(task, "Compilation timed out"), nil
+			}
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				return &TestRunResult{Passed: false, Results: []TestResult{}, Error: "Compile Error: " + output.String()}, nil
+			}
+			return nil, err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.Timeout)
+	defer cancel()
+
+	// ulimit -t caps CPU time. Memory is capped by ulimit -v where the
+	// runtime tolerates it, otherwise by the language's own flag. The
+	// process limit is set once for the whole server; see limitProcs.
+	limits := fmt.Sprintf("ulimit -t %d", s.CPUSeconds)
+	if lang.LimitAddressSpace {
+		limits += fmt.Sprintf(" && ulimit -v %d", s.MemoryMB*1024)
+	}
+	argv := lang.Run(dir, s.MemoryMB)
+	cmd := exec.CommandContext(ctx, "sh", append([]string{"-c", limits + ` && exec "$0" "$@"`}, argv...)...)
+	cmd.Dir = dir
+	cmd.Env = append(s.baseEnv(), lang.Env(dir)...)
+	s.sandbox(cmd, true)
+	cmd.Stdin = bytes.NewReader(input)
+	stdout := &limitedBuffer{max: runnerMaxOutput}
+	stderr := &limitedBuffer{max: runnerMaxOutput}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.WaitDelay = runnerWaitDelay
+
+	runErr := cmd.Run()
+	killGroup(cmd)
+	if ctx.Err() == context.DeadlineExceeded {
+		return failAll(task, "Execution timed out (possible infinite loop)"), nil
+	}
+
+	var output harnessOutput
+	if err := json.Unmarshal(stdout.Bytes(), &output); err != nil {
+		if runErr != nil {
+			var exitErr *exec.ExitError
+			if errors.As(runErr, &exitErr) {
+				// Killed by the CPU or memory limit, or crashed outright
+				return failAll(task, "Execution aborted: resource limit exceeded"), nil
+			}
+			return nil, runErr
+		}
+		return nil, fmt.Errorf("invalid harness output: %v (stderr: %s)", err, stderr.String())
+	}
+	return judge(task, &output), nil
+}
+
+// makeRunDir creates a directory for one run. Runs live in a parent
+// that can't be listed, so one submission can't find another's code.
+func (s *SubprocessRunner) makeRunDir() (string, error) {
+	parent := filepath.Join(os.TempDir(), "lgtm-runs")
+	if err := os.MkdirAll(parent, 0o711); err != nil {
+		return "", err
+	}
+	if err := os.Chmod(parent, 0o711); err != nil {
+		return "", err
+	}
+	dir, err := os.MkdirTemp(parent, "run-")
+	if err != nil {
+		return "", err
+	}
+	return dir, os.Chmod(dir, 0o755)
+}
+
+func (s *SubprocessRunner) baseEnv() []string {
+	return []string{"PATH=" + os.Getenv("PATH")}
+}
+
+// judge compares harness output with the expected values
+func judge(task *Task, output *harnessOutput) *TestRunResult {
+	if output.Error != "" {
+		return &TestRunResult{Passed: false, Results: []TestResult{}, Error: output.Error}
+	}
+
+	allPassed := len(output.Results) == len(task.TestCases)
+	results := make([]TestResult, 0, len(task.TestCases))
+	for i, tc := range task.TestCases {
+		result := TestResult{Input: tc.Input, Expected: tc.Expected}
+		switch {
+		case i >= len(output.Results):
+			result.Error = "Not run"
+		case output.Results[i].TimedOut:
+			result.Actual = "TIMEOUT"
+			result.Error = "Execution timed out (possible infinite loop)"
+		case output.Results[i].Error != "":
+			result.Error = output.Results[i].Error
+		default:
+			result.Actual = output.Results[i].Actual
+			result.ExecutionTime = output.Results[i].ExecutionTime
+			result.Passed = valuesEqual(result.Actual, tc.Expected)
+		}
+		if !result.Passed {
+			allPassed = false
+		}
+		results = append(results, result)
+	}
+
+	run := &TestRunResult{Passed: allPassed, Results: results}
+	if !allPassed {
+		run.Error = "Some test cases failed"
+	}
+	return run
+}
+
+// valuesEqual mirrors deepEqual in client/src/lib/codeRunner.ts so the
+// server agrees with what players see locally. Values of different types
+// compare by their string form, as JavaScript's String() would print them.
+func valuesEqual(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+
+	switch av := a.(type) {
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok {
+			return false
+		}
+		if len(av) != len(bv) {
+			return false
+		}
+		for i := range av {
+			if !valuesEqual(av[i], bv[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		if len(av) != len(bv) {
+			return false
+		}
+		for k, v := range av {
+			other, ok := bv[k]
+			if !ok || !valuesEqual(v, other) {
+				return false
+			}
+		}
+		return true
+	}
+
+	switch b.(type) {
+	case []interface{}, map[string]interface{}:
+		return false
+	}
+	if a == b {
+		return true
+	}
+	return jsString(a) == jsString(b)
+}
+
+func jsString(v interface{}) string {
+	switch v := v.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case string:
+		return v
+	}
+	return fmt.Sprint(v)
+}
+
+// failAll marks every test case failed with the same error
+func failAll(task *Task, message string) *TestRunResult {
+	results := make([]TestResult, 0, len(task.TestCases))
+	for _, tc := range task.TestCases {
+		results = append(results, TestResult{
+			Input:    tc.Input,
+			Expected: tc.Expected,
+			Passed:   false,
+			Error:    message,
+		})
+	}
+	return &TestRunResult{Passed: false, Results: results, Error: message}
+}
+
+// limitedBuffer discards anything written past max bytes
+type limitedBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.Len(); room > 0 {
+		if len(p) > room {
+			b.Buffer.Write(p[:room])
+		} else {
+			b.Buffer.Write(p)
+		}
+	}
+	return len(p), nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValuesEqual(t *testing.T) {
+	tests := []struct {
+		a, b string // JSON
+		want bool
+	}{
+		{`1`, `1`, true},
+		{`1`, `1.0`, true},
+		{`1`, `2`, false},
+		{`"1"`, `1`, true}, // String(1) == "1", as deepEqual compares
+		{`true`, `"true"`, true},
+		{`null`, `null`, true},
+		{`null`, `0`, false},
+		{`null`, `"null"`, false},
+		{`[1,[2,3]]`, `[1,[2,3]]`, true},
+		{`[1,2]`, `[1,2,3]`, false},
+		{`[1,2]`, `[2,1]`, false},
+		{`[]`, `{}`, false},
+		{`[1]`, `"1"`, false},
+		{`{"a":1,"b":[true]}`, `{"b":[true],"a":1}`, true},
+		{`{"a":1}`, `{"a":1,"b":2}`, false},
+		{`{"a":1}`, `{"b":1}`, false},
+		{`{"a":null}`, `{}`, false},
+	}
+	for _, tt := range tests {
+		var a, b interface{}
+		if err := json.Unmarshal([]byte(tt.a), &a); err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal([]byte(tt.b), &b); err != nil {
+			t.Fatal(err)
+		}
+		if got := valuesEqual(a, b); got != tt.want {
+			t.Errorf("valuesEqual(%s, %s) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+		if got := valuesEqual(b, a); got != tt.want {
+			t.Errorf("valuesEqual(%s, %s) = %v, want %v", tt.b, tt.a, got, tt.want)
+		}
+	}
+}
+
+func TestJudge(t *testing.T) {
+	task := &Task{TestCases: []TestCase{{Input: 1.0, Expected: 2.0}, {Input: 2.0, Expected: 4.0}}}
+	tests := []struct {
+		name   string
+		output string   // harness output, as JSON
+		passed []bool   // per test case
+		errors []string // per test case
+		error  string
+	}{
+		{"all pass", `{"results":[{"actual":2},{"actual":4}]}`, []bool{true, true}, []string{"", ""}, ""},
+		{"wrong answer", `{"results":[{"actual":2},{"actual":5}]}`, []bool{true, false}, []string{"", ""}, "Some test cases failed"},
+		{"timed out", `{"results":[{"actual":2},{"timedOut":true}]}`, []bool{true, false}, []string{"", "Execution timed out (possible infinite loop)"}, "Some test cases failed"},
+		{"threw", `{"results":[{"error":"boom"},{"actual":4}]}`, []bool{false, true}, []string{"boom", ""}, "Some test cases failed"},
+		{"cut short", `{"results":[{"actual":2}]}`, []bool{true, false}, []string{"", "Not run"}, "Some test cases failed"},
+		{"harness error", `{"error":"Syntax Error: x"}`, nil, nil, "Syntax Error: x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var output harnessOutput
+			if err := json.Unmarshal([]byte(tt.output), &output); err != nil {
+				t.Fatal(err)
+			}
+			result := judge(task, &output)
+			if result.Passed != (tt.error == "") || result.Error != tt.error {
+				t.Errorf("got passed %v, error %q; want error %q", result.Passed, result.Error, tt.error)
+			}
+			if len(result.Results) != len(tt.passed) {
+				t.Fatalf("got %d results, want %d", len(result.Results), len(tt.passed))
+			}
+			for i, r := range result.Results {
+				if r.Passed != tt.passed[i] || r.Error != tt.errors[i] {
+					t.Errorf("result %d: got passed %v, error %q; want %v, %q", i, r.Passed, r.Error, tt.passed[i], tt.errors[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSubprocessRunner(t *testing.T) {
+	task := &Task{
+		FunctionName: "double",
+		TestCases:    []TestCase{{Input: 1.0, Expected: 2.0}, {Input: []interface{}{1.0, "a"}, Expected: []interface{}{1.0, "a", 1.0, "a"}}},
+	}
+	tests := []struct {
+		name     string
+		language string
+		code     string
+		passed   bool
+		error    string // the run's error contains this
+	}{
+		{"js passes", "javascript", "function double(x) { return Array.isArray(x) ? x.concat(x) : x * 2 }", true, ""},
+		{"js wrong", "javascript", "function double(x) { return x }", false, "Some test cases failed"},
+		{"js loops", "javascript", "function double(x) { while (true) {} }", false, "Some test cases failed"},
+		{"js syntax error", "javascript", "function double(x) {", false, "Syntax Error"},
+		{"js misnamed", "javascript", "function triple(x) { return x * 3 }", false, `Function "double" not found`},
+		{"python passes", "python", "def double(x):\n    return x + x if isinstance(x, list) else x * 2", true, ""},
+		{"python throws", "python", "def double(x):\n    raise ValueError('no')", false, "Some test cases failed"},
+		{"python loops", "python", "def double(x):\n    while True:\n        pass", false, "Some test cases failed"},
+		{"python syntax error", "python", "def double(x)", false, "Syntax Error"},
+	}
+	runner := testRunner()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			skipWithoutRuntime(t, runner, tt.language)
+			result, err := runner.Run(context.Background(), task, tt.language, tt.code)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Passed != tt.passed || !strings.Contains(result.Error, tt.error) {
+				t.Errorf("got passed %v, error %q; want %v, %q", result.Passed, result.Error, tt.passed, tt.error)
+			}
+		})
+	}
+}
+
+// TestRunnerKillsProcessGroup starts a child that outlives the submission
+// while holding its output open. The run must finish on time and take the
+// child with it.
+func TestRunnerKillsProcessGroup(t *testing.T) {
+	runner := testRunner()
+	skipWithoutRuntime(t, runner, "python")
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	code := fmt.Sprintf(`import subprocess
+child = subprocess.Popen(["sleep", "60"])
+with open(%q, "w") as f:
+    f.write(str(child.pid))
+
+def double(x):
+    return x * 2
+`, pidFile)
+	task := &Task{FunctionName: "double", TestCases: []TestCase{{Input: 1.0, Expected: 2.0}}}
+
+	start := time.Now()
+	result, err := runner.Run(context.Background(), task, "python", code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > runner.Timeout {
+		t.Errorf("run took %v", elapsed)
+	}
+	if !result.Passed {
+		t.Errorf("run failed: %s", result.Error)
+	}
+
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(string(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for processRunning(pid) {
+		if time.Now().After(deadline) {
+			t.Fatalf("child %d is still running", pid)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func testRunner() *SubprocessRunner {
+	config := DefaultConfig()
+	config.RunnerSandbox = false
+	config.RunnerTestTimeout = Duration(200 * time.Millisecond)
+	return NewSubprocessRunner(config)
+}
+
+func skipWithoutRuntime(t *testing.T, runner *SubprocessRunner, language string) {
+	t.Helper()
+	if _, err := exec.LookPath(runner.Languages[language].Run("", 0)[0]); err != nil {
+		t.Skipf("%s isn't installed", language)
+	}
+}
+
+// processRunning reports whether pid is alive and not a zombie
+func processRunning(pid int) bool {
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return false
+	}
+	// The state follows the command name, which is in parentheses
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	return len(fields) > 0 && fields[0] != "Z"
+}
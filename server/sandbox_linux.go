@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// checkSandbox reports whether this process can start sandboxed
+// submissions: switching user and creating namespaces needs root
+func checkSandbox() error {
+	if os.Geteuid() != 0 {
+		return errors.New("the runner sandbox needs the server to run as root")
+	}
+	return nil
+}
+
+// limitProcs caps how many processes and threads sandboxed submissions
+// may have between them, which stops fork bombs. RLIMIT_NPROC counts
+// per user and root is exempt, so setting it on the server only binds
+// children once they switch to the runner user.
+func limitProcs(n int) error {
+	limit := &syscall.Rlimit{Cur: uint64(n), Max: uint64(n)}
+	return syscall.Setrlimit(rlimitNproc, limit)
+}
+
+// namespaceFlags give a command its own network, PID, IPC and UTS
+// namespaces, so it has no network and everything it starts dies with it
+const namespaceFlags = syscall.CLONE_NEWNET | syscall.CLONE_NEWPID |
+	syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS
+
+// checkNamespaces starts a process in the namespaces submissions run in.
+// Creating them needs CAP_SYS_ADMIN, which containers usually lack:
+// Docker's default seccomp profile refuses the clone without it.
+func checkNamespaces() error {
+	cmd := exec.Command("true")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Cloneflags: namespaceFlags}
+	return cmd.Run()
+}
+
+// sandbox sets up cmd to run in its own namespaces when Namespaces is
+// set. untrusted commands, the ones that execute submitted code, also
+// drop to the runner user, which can't read the server's data files.
+func (s *SubprocessRunner) sandbox(cmd *exec.Cmd, untrusted bool) {
+	// A process group of its own lets a timeout kill everything the
+	// command started, not just the command
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Pdeathsig: syscall.SIGKILL}
+	cmd.Cancel = func() error {
+		killGroup(cmd)
+		return nil
+	}
+	if !s.Sandbox {
+		return
+	}
+	if s.Namespaces {
+		cmd.SysProcAttr.Cloneflags = namespaceFlags
+	}
+	if untrusted {
+		cmd.SysProcAttr.Credential = &syscall.Credential{
+			Uid:    uint32(s.UID),
+			Gid:    uint32(s.GID),
+			Groups: []uint32{},
+		}
+	}
+}
+
+// killGroup kills what is left of cmd's process group, such as children
+// that outlived it while holding its output open
+func killGroup(cmd *exec.Cmd) {
+	if cmd.Process != nil {
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
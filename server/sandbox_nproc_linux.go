@@ -0,0 +1,7 @@
+//go:build linux && (386 || amd64 || arm || arm64 || loong64 || ppc64 || ppc64le || riscv64 || s390x)
+
+package main
+
+// rlimitNproc is RLIMIT_NPROC, which package syscall doesn't define. It
+// is 6 on the architectures that use the kernel's generic resource.h.
+const rlimitNproc = 6
@@ -0,0 +1,7 @@
+//go:build linux && (mips || mipsle || mips64 || mips64le)
+
+package main
+
+// rlimitNproc is RLIMIT_NPROC, which package syscall doesn't define.
+// MIPS numbers its resource limits differently from other architectures.
+const rlimitNproc = 8
@@ -0,0 +1,28 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os/exec"
+)
+
+func checkSandbox() error {
+	return errors.New("the runner sandbox is only supported on Linux")
+}
+
+func checkNamespaces() error {
+	return errors.New("namespaces are only supported on Linux")
+}
+
+func limitProcs(n int) error {
+	return nil
+}
+
+func (s *SubprocessRunner) sandbox(cmd *exec.Cmd, untrusted bool) {}
+
+func killGroup(cmd *exec.Cmd) {
+	if cmd.Process != nil {
+		cmd.Process.Kill()
+	}
+}
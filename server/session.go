@@ -0,0 +1,298 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"time"
+)
+
+var errInvalidResumeToken = errors.New("invalid resume token")
+
+var errInvalidToken = errors.New("invalid token")
+
+// detachedPlayer keeps a disconnected player's seat and role until the
+// grace period runs out
+type detachedPlayer struct {
+	player *Player
+	expiry *time.Timer
+}
+
+type ChatRecord struct {
+	PlayerID    string `json:"playerId"`
+	PlayerName  string `json:"playerName"`
+	PlayerColor string `json:"playerColor"`
+	Message     string `json:"message"`
+	Channel     string `json:"channel,omitempty"`
+	Timestamp   int64  `json:"timestamp"`
+}
+
+func newSessionSecret() []byte {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		log.Fatalf("[LGTM] Failed to generate session secret: %v", err)
+	}
+	return secret
+}
+
+// signToken returns payload with its HMAC, both base64 encoded
+func signToken(secret []byte, payload string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyToken checks a token from signToken and returns its payload
+func verifyToken(secret []byte, token string) (string, error) {
+	encodedPayload, encodedSig, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", errInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return "", errInvalidToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(encodedSig)
+	if err != nil {
+		return "", errInvalidToken
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return "", errInvalidToken
+	}
+	return string(payload), nil
+}
+
+// IssueResumeToken signs the room code and player ID so a reconnecting
+// client can reclaim its seat
+func (h *Hub) IssueResumeToken(roomCode, playerID string) string {
+	return signToken(h.secret, roomCode+":"+playerID)
+}
+
+// ParseResumeToken verifies a token and returns the room code and player ID
+func (h *Hub) ParseResumeToken(token string) (string, string, error) {
+	payload, err := verifyToken(h.secret, token)
+	if err != nil {
+		return "", "", errInvalidResumeToken
+	}
+
+	roomCode, playerID, ok := strings.Cut(payload, ":")
+	if !ok {
+		return "", "", errInvalidResumeToken
+	}
+	return roomCode, playerID, nil
+}
+
+// detachLocked removes a client from the room, passing the host on if
+// they held it. During a game the player entry is parked for the resume
+// grace period instead of being dropped.
+// Returns true if the player was parked. Caller must hold r.mutex.
+func (r *Room) detachLocked(client *Client) bool {
+	player := r.players[client]
+	delete(r.players, client)
+	client.room = nil
+	r.passHostLocked()
+
+	if player == nil || (r.gameState != StatePlaying && r.gameState != StateVoting) {
+		return false
+	}
+
+	playerID := player.ID
+	r.detached[playerID] = &detachedPlayer{
+		player: player,
+		expiry: time.AfterFunc(r.config.ResumeGracePeriod.D(), func() {
+			r.expireDetached(playerID)
+		}),
+	}
+	log.Printf("🔌 [LGTM] %s disconnected from room %s, holding seat for %v", player.Name, r.code, r.config.ResumeGracePeriod)
+	return true
+}
+
+// DetachPlayer is the locking wrapper around detachLocked
+func (r *Room) DetachPlayer(client *Client) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.detachLocked(client)
+}
+
+func (r *Room) expireDetached(playerID string) {
+	r.mutex.Lock()
+	d, ok := r.detached[playerID]
+	if ok {
+		delete(r.detached, playerID)
+	}
+	empty := len(r.players) == 0 && len(r.detached) == 0
+	r.mutex.Unlock()
+
+	if !ok {
+		return
+	}
+
+	log.Printf("⌛ [LGTM] %s did not reconnect to room %s", d.player.Name, r.code)
+	if empty {
+		r.hub.DeleteRoom(r.code)
+		r.Close()
+		return
+	}
+	r.BroadcastPlayerList()
+}
+
+// ResumePlayer rebinds a parked (or stale, not yet detected) player
+// entry to a new client
+func (r *Room) ResumePlayer(client *Client, playerID string) *Player {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var player *Player
+	if d, ok := r.detached[playerID]; ok {
+		d.expiry.Stop()
+		delete(r.detached, playerID)
+		player = d.player
+	} else {
+		// The old socket may still look alive; take the seat over from it
+		for old, p := range r.players {
+			if p.ID == playerID && old != client {
+				delete(r.players, old)
+				old.room = nil
+				old.cleanup()
+				player = p
+				break
+			}
+		}
+	}
+
+	if player == nil {
+		return nil
+	}
+
+	client.id = player.ID
+	client.room = r
+	client.StopReplay()
+	r.players[client] = player
+	// Everyone else may have dropped while the host was away
+	r.passHostLocked()
+	return player
+}
+
+// allPlayersLocked returns connected and parked players.
+// Caller must hold r.mutex.
+func (r *Room) allPlayersLocked() []*Player {
+	players := make([]*Player, 0, len(r.players)+len(r.detached))
+	for _, p := range r.players {
+		players = append(players, p)
+	}
+	for _, d := range r.detached {
+		players = append(players, d.player)
+	}
+	return players
+}
+
+func (r *Room) IsEmpty() bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return len(r.players) == 0 && len(r.detached) == 0
+}
+
+func (r *Room) AddChatMessage(record ChatRecord) {
+	r.mutex.Lock()
+	r.chatHistory = append(r.chatHistory, record)
+
+	// Keep only last 100 messages
+	if len(r.chatHistory) > 100 {
+		r.chatHistory = r.chatHistory[len(r.chatHistory)-100:]
+	}
+	r.mutex.Unlock()
+
+	data, _ := json.Marshal(ChatMessage{
+		Type:       "chat-message",
+		ChatRecord: record,
+	})
+
+	switch record.Channel {
+	case "":
+		r.broadcast <- data
+	default:
+		// Private messages skip the broadcast path, so log them here
+		r.mutex.Lock()
+		r.recordLocked(data)
+		r.mutex.Unlock()
+		r.SendToChannel(record.Channel, data)
+	}
+}
+
+// SessionSnapshot captures everything a resuming client needs to rebuild
+// its view of the game; the caller fills in the resume token
+func (r *Room) SessionSnapshot(player *Player) SessionResumedMessage {
+	players := r.GetPlayersPublic()
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	aliveCount := 0
+	for _, p := range r.allPlayersLocked() {
+		if p.IsAlive {
+			aliveCount++
+		}
+	}
+
+	var myVote *string
+	if target, ok := r.votes[player.ID]; ok {
+		myVote = &target
+	}
+
+	editHistory := make([]EditRecord, len(r.editHistory))
+	copy(editHistory, r.editHistory)
+	// Private channels only go back to players who could read them
+	chatHistory := make([]ChatRecord, 0, len(r.chatHistory))
+	for _, record := range r.chatHistory {
+		if !canReadChannel(player, record.Channel) {
+			continue
+		}
+		chatHistory = append(chatHistory, record)
+	}
+
+	language := ""
+	if r.currentTask != nil {
+		language = r.currentTask.Language
+	}
+
+	snapshot := SessionResumedMessage{
+		Type:                "session-resumed",
+		RoomCode:            r.code,
+		Player:              player,
+		Role:                player.Role,
+		Players:             players,
+		GameState:           r.gameState,
+		Task:                r.currentTask,
+		Language:            language,
+		EditorMode:          editorMode(language),
+		Code:                r.currentCode,
+		Revision:            r.revision,
+		TimeRemaining:       r.timeRemaining,
+		VotingTimeRemaining: r.votingTimeRemaining,
+		MeetingCooldown:     r.meetingCooldownSecondsLocked(),
+		VotesCount: VoteCount{
+			Voted: len(r.votes),
+			Total: aliveCount,
+		},
+		MyVote:       myVote,
+		ChatMessages: chatHistory,
+		EditHistory:  editHistory,
+	}
+	if player.Role == "impostor" {
+		snapshot.Teammates = r.impostorTeammatesLocked(player)
+	}
+	if !player.IsAlive {
+		ghost := r.ghostModeLocked(player)
+		snapshot.Ghost = &ghost
+	}
+	return snapshot
+}
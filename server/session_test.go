@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestVerifyToken(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	token := signToken(secret, "ABCD:player-1")
+	payload, sig, _ := strings.Cut(token, ".")
+	other := signToken(secret, "WXYZ:player-1")
+	otherPayload, otherSig, _ := strings.Cut(other, ".")
+
+	tests := []struct {
+		name   string
+		secret []byte
+		token  string
+		want   string
+		err    error
+	}{
+		{"valid", secret, token, "ABCD:player-1", nil},
+		{"wrong secret", []byte("another secret"), token, "", errInvalidToken},
+		{"payload swapped", secret, otherPayload + "." + sig, "", errInvalidToken},
+		{"signature swapped", secret, payload + "." + otherSig, "", errInvalidToken},
+		{"no signature", secret, payload, "", errInvalidToken},
+		{"empty signature", secret, payload + ".", "", errInvalidToken},
+		{"bad base64", secret, "!!!." + sig, "", errInvalidToken},
+		{"empty", secret, "", "", errInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := verifyToken(tt.secret, tt.token)
+			if !errors.Is(err, tt.err) || got != tt.want {
+				t.Errorf("got %q, %v; want %q, %v", got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func TestResumeToken(t *testing.T) {
+	hub := &Hub{secret: []byte("0123456789abcdef0123456789abcdef")}
+	roomCode, playerID, err := hub.ParseResumeToken(hub.IssueResumeToken("ABCD", "player-1"))
+	if err != nil || roomCode != "ABCD" || playerID != "player-1" {
+		t.Errorf("got %q, %q, %v; want ABCD, player-1", roomCode, playerID, err)
+	}
+
+	// A validly signed payload that isn't a resume token
+	if _, _, err := hub.ParseResumeToken(signToken(hub.secret, "no separator")); !errors.Is(err, errInvalidResumeToken) {
+		t.Errorf("got %v, want %v", err, errInvalidResumeToken)
+	}
+}
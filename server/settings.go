@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Bounds for host-configurable room settings
+const (
+	minPlayersLimit = 3
+	maxPlayersLimit = 10
+	minGameTime     = 60
+	maxGameTime     = 900
+	minVotingTime   = 15
+	maxVotingTime   = 180
+
+	maxMeetingCooldown   = 300
+	minMeetingsPerPlayer = 1
+	maxMeetingsPerPlayer = 5
+)
+
+type RoomSettings struct {
+	MinPlayers    int `json:"minPlayers"`
+	MaxPlayers    int `json:"maxPlayers"`
+	ImpostorCount int `json:"impostorCount"`
+	GameTime      int `json:"gameTime"`   // seconds
+	VotingTime    int `json:"votingTime"` // seconds
+	// MeetingCooldown is the wait, in seconds, after the game starts and
+	// after each meeting before anyone can call another
+	MeetingCooldown   int `json:"meetingCooldown"`
+	MeetingsPerPlayer int `json:"meetingsPerPlayer"`
+	// Language restricts tasks to one language; empty plays each task in
+	// its own language
+	Language   string     `json:"language"`
+	TaskFilter TaskFilter `json:"taskFilter"`
+	// Visibility controls the room browser listing; the password itself
+	// is never part of the settings
+	Visibility string `json:"visibility"`
+}
+
+// DefaultRoomSettings are a new room's settings; the timers come from
+// the server config
+func DefaultRoomSettings(config *Config) RoomSettings {
+	return RoomSettings{
+		MinPlayers:    4,
+		MaxPlayers:    4,
+		ImpostorCount: 1,
+		GameTime:      config.GameTime,
+		VotingTime:    config.VotingTime,
+
+		MeetingCooldown:   config.MeetingCooldown,
+		MeetingsPerPlayer: config.MeetingsPerPlayer,
+		TaskFilter:        TaskFilter{Difficulties: []string{}, Tags: []string{}},
+		Visibility:        VisibilityPrivate,
+	}
+}
+
+// Validate checks the settings against the server bounds. Impostors must
+// stay under half of the smallest allowed room.
+func (s RoomSettings) Validate() error {
+	if s.MinPlayers < minPlayersLimit || s.MinPlayers > maxPlayersLimit {
+		return fmt.Errorf("minimum players must be between %d and %d", minPlayersLimit, maxPlayersLimit)
+	}
+	if s.MaxPlayers < s.MinPlayers || s.MaxPlayers > maxPlayersLimit {
+		return fmt.Errorf("maximum players must be between %d and %d", s.MinPlayers, maxPlayersLimit)
+	}
+	if s.ImpostorCount < 1 {
+		return fmt.Errorf("there must be at least 1 impostor")
+	}
+	if s.ImpostorCount*2 >= s.MinPlayers {
+		return fmt.Errorf("impostors must be less than half of %d players", s.MinPlayers)
+	}
+	if s.GameTime < minGameTime || s.GameTime > maxGameTime {
+		return fmt.Errorf("game time must be between %d and %d seconds", minGameTime, maxGameTime)
+	}
+	if s.VotingTime < minVotingTime || s.VotingTime > maxVotingTime {
+		return fmt.Errorf("voting time must be between %d and %d seconds", minVotingTime, maxVotingTime)
+	}
+	if s.MeetingCooldown < 0 || s.MeetingCooldown > maxMeetingCooldown {
+		return fmt.Errorf("meeting cooldown must be between 0 and %d seconds", maxMeetingCooldown)
+	}
+	if s.MeetingsPerPlayer < minMeetingsPerPlayer || s.MeetingsPerPlayer > maxMeetingsPerPlayer {
+		return fmt.Errorf("meetings per player must be between %d and %d", minMeetingsPerPlayer, maxMeetingsPerPlayer)
+	}
+	if s.Language != "" && Languages[s.Language] == nil {
+		return fmt.Errorf("unsupported language %q", s.Language)
+	}
+	switch s.Visibility {
+	case VisibilityPublic, VisibilityPrivate, VisibilityPassword:
+	default:
+		return fmt.Errorf("visibility must be public, private or password")
+	}
+	return s.TaskFilter.Validate()
+}
+
+func (r *Room) Settings() RoomSettings {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.settings
+}
+
+// TaskPool reports how many tasks the current settings can pick from
+func (r *Room) TaskPool() TaskPool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return NewTaskPool(GetTasks(), r.settings.TaskFilter, r.settings.Language, r.playedTasks)
+}
+
+// UpdateSettings applies a validated settings change while the room is
+// still in the lobby. A nil password keeps the current one.
+func (r *Room) UpdateSettings(settings RoomSettings, password *string) error {
+	if err := settings.Validate(); err != nil {
+		return err
+	}
+	if password != nil && len(*password) > maxRoomPasswordLength {
+		return fmt.Errorf("password can be at most %d characters", maxRoomPasswordLength)
+	}
+
+	r.mutex.Lock()
+	if r.gameState != StateLobby {
+		r.mutex.Unlock()
+		return fmt.Errorf("settings can only be changed in the lobby")
+	}
+	if len(r.players) > settings.MaxPlayers {
+		r.mutex.Unlock()
+		return fmt.Errorf("%d players are already in the room", len(r.players))
+	}
+	pool := NewTaskPool(GetTasks(), settings.TaskFilter, settings.Language, r.playedTasks)
+	if pool.Matching == 0 {
+		r.mutex.Unlock()
+		return fmt.Errorf("no tasks match these filters")
+	}
+	if settings.Visibility == VisibilityPassword {
+		if password != nil && *password != "" {
+			r.setPasswordLocked(*password)
+		} else if r.passwordHash == nil {
+			r.mutex.Unlock()
+			return fmt.Errorf("a password is required")
+		}
+	} else {
+		r.passwordHash = nil
+		r.passwordSalt = nil
+	}
+	r.settings = settings
+	r.timeRemaining = settings.GameTime
+	r.votingTimeRemaining = settings.VotingTime
+	r.mutex.Unlock()
+
+	msg := SettingsUpdatedMessage{
+		Type:     "settings-updated",
+		Settings: settings,
+		TaskPool: pool,
+	}
+	data, _ := json.Marshal(msg)
+	r.broadcast <- data
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRoomSettingsValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(s *RoomSettings)
+		want   string // the error contains this; "" for none
+	}{
+		{"defaults", func(s *RoomSettings) {}, ""},
+		{"largest room", func(s *RoomSettings) { s.MinPlayers, s.MaxPlayers, s.ImpostorCount = 10, 10, 4 }, ""},
+		{"too few players", func(s *RoomSettings) { s.MinPlayers = minPlayersLimit - 1 }, "minimum players"},
+		{"too many players", func(s *RoomSettings) { s.MaxPlayers = maxPlayersLimit + 1 }, "maximum players"},
+		{"max under min", func(s *RoomSettings) { s.MinPlayers, s.MaxPlayers = 6, 5 }, "maximum players must be between 6"},
+		{"no impostors", func(s *RoomSettings) { s.ImpostorCount = 0 }, "at least 1 impostor"},
+		{"half impostors", func(s *RoomSettings) { s.MinPlayers, s.MaxPlayers, s.ImpostorCount = 6, 8, 3 }, "less than half of 6"},
+		{"impostors against a larger max", func(s *RoomSettings) { s.MinPlayers, s.MaxPlayers, s.ImpostorCount = 4, 10, 2 }, "less than half of 4"},
+		{"short game", func(s *RoomSettings) { s.GameTime = minGameTime - 1 }, "game time"},
+		{"long game", func(s *RoomSettings) { s.GameTime = maxGameTime + 1 }, "game time"},
+		{"short vote", func(s *RoomSettings) { s.VotingTime = minVotingTime - 1 }, "voting time"},
+		{"long vote", func(s *RoomSettings) { s.VotingTime = maxVotingTime + 1 }, "voting time"},
+		{"no meeting cooldown", func(s *RoomSettings) { s.MeetingCooldown = 0 }, ""},
+		{"negative meeting cooldown", func(s *RoomSettings) { s.MeetingCooldown = -1 }, "meeting cooldown"},
+		{"long meeting cooldown", func(s *RoomSettings) { s.MeetingCooldown = maxMeetingCooldown + 1 }, "meeting cooldown"},
+		{"no meetings", func(s *RoomSettings) { s.MeetingsPerPlayer = 0 }, "meetings per player"},
+		{"too many meetings", func(s *RoomSettings) { s.MeetingsPerPlayer = maxMeetingsPerPlayer + 1 }, "meetings per player"},
+		{"language", func(s *RoomSettings) { s.Language = "python" }, ""},
+		{"unknown language", func(s *RoomSettings) { s.Language = "cobol" }, "unsupported language"},
+		{"bad filter", func(s *RoomSettings) { s.TaskFilter.Difficulties = []string{"brutal"} }, "difficulty must be one of"},
+		{"public", func(s *RoomSettings) { s.Visibility = VisibilityPublic }, ""},
+		{"unknown visibility", func(s *RoomSettings) { s.Visibility = "hidden" }, "visibility"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := DefaultRoomSettings(DefaultConfig())
+			tt.change(&settings)
+			err := settings.Validate()
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("got %v, want no error", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got %v, want an error about %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const drainPollInterval = time.Second
+
+// Drain stops new rooms and games, tells every client the server is going
+// away, and waits up to timeout for running games to finish. It returns
+// early once no game is in progress, after the finished games' ratings
+// are saved.
+func (h *Hub) Drain(timeout time.Duration) {
+	h.mutex.Lock()
+	h.drainUntil = time.Now().Add(timeout)
+	h.draining.Store(true)
+	clients := make([]*Client, 0, len(h.clients))
+	for client := range h.clients {
+		clients = append(clients, client)
+	}
+	h.mutex.Unlock()
+
+	data := h.drainingMessage()
+	for _, client := range clients {
+		client.trySend(data)
+	}
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	// Games that ended while draining may still be writing ratings
+	defer h.ratings.Wait()
+
+	// Check after the first tick so the notice reaches clients before
+	// their connections close
+	for {
+		select {
+		case <-ticker.C:
+			if h.ActiveGames() == 0 {
+				log.Printf("[LGTM] No games in progress, shutting down")
+				return
+			}
+		case <-deadline.C:
+			log.Printf("[LGTM] Drain window over with %d game(s) still running", h.ActiveGames())
+			return
+		}
+	}
+}
+
+func (h *Hub) IsDraining() bool {
+	return h.draining.Load()
+}
+
+func (h *Hub) drainingMessage() []byte {
+	h.mutex.RLock()
+	deadline := h.drainUntil
+	h.mutex.RUnlock()
+
+	data, _ := json.Marshal(NoticeMessage{
+		Type:     "server-draining",
+		Message:  "Server is restarting. Running games can finish, but no new games can start.",
+		Deadline: deadline.UnixMilli(),
+	})
+	return data
+}
+
+// ActiveGames counts rooms with a game in progress
+func (h *Hub) ActiveGames() int {
+	active := 0
+	for _, room := range h.Rooms() {
+		room.mutex.RLock()
+		if room.gameState == StatePlaying || room.gameState == StateVoting {
+			active++
+		}
+		room.mutex.RUnlock()
+	}
+	return active
+}
+
+// CloseAll closes every websocket with a going-away close frame
+func (h *Hub) CloseAll() {
+	h.mutex.RLock()
+	clients := make([]*Client, 0, len(h.clients))
+	for client := range h.clients {
+		clients = append(clients, client)
+	}
+	h.mutex.RUnlock()
+
+	message := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+	for _, client := range clients {
+		// WriteControl may run alongside writePump. Closing the conn ends
+		// readPump, which unregisters the client as usual.
+		client.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(h.config.WriteWait.D()))
+		client.conn.Close()
+	}
+	log.Printf("[LGTM] Closed %d connection(s)", len(clients))
+}
+
+// ServeHealthz handles GET /healthz; the process is alive while it answers
+func ServeHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// ServeReadyz handles GET /readyz; it fails once draining starts so load
+// balancers stop sending new players here
+func ServeReadyz(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if hub.IsDraining() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":      "draining",
+			"activeGames": hub.ActiveGames(),
+		})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+)
+
+// Spectators are read-only clients attached to a room. They receive the
+// room's broadcasts (code, public chat, timers, meetings) but are never
+// in r.players, so every player-only action ignores them and no role is
+// shown to them before game-ended.
+
+func (r *Room) AddSpectator(client *Client) {
+	r.mutex.Lock()
+	r.spectators[client] = true
+	client.spectating = r
+	r.mutex.Unlock()
+	client.StopReplay()
+
+	r.SendToClient(client, r.SpectatorSnapshot())
+	r.BroadcastPlayerList()
+}
+
+func (r *Room) RemoveSpectator(client *Client) {
+	r.mutex.Lock()
+	delete(r.spectators, client)
+	client.spectating = nil
+	r.mutex.Unlock()
+}
+
+// dropSpectatorLocked removes a spectator that can't keep up.
+// Caller must hold r.mutex.
+func (r *Room) dropSpectatorLocked(client *Client) {
+	delete(r.spectators, client)
+	client.spectating = nil
+	select {
+	case client.hub.unregister <- client:
+	default:
+	}
+}
+
+// SendToSpectators delivers a message that doesn't go through broadcast
+func (r *Room) SendToSpectators(data []byte) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	for client := range r.spectators {
+		// A slow spectator is dropped by Run on the next broadcast
+		client.trySend(data)
+	}
+}
+
+// CloseSpectators tells spectators the room is gone and detaches them
+func (r *Room) CloseSpectators() {
+	data, _ := json.Marshal(RoomClosedMessage{
+		Type:     "room-closed",
+		RoomCode: r.code,
+	})
+
+	r.mutex.Lock()
+	spectators := make([]*Client, 0, len(r.spectators))
+	for client := range r.spectators {
+		client.spectating = nil
+		spectators = append(spectators, client)
+	}
+	r.spectators = make(map[*Client]bool)
+	r.mutex.Unlock()
+
+	for _, client := range spectators {
+		client.deliver(data)
+	}
+}
+
+// SpectatorSnapshot is the role-free view of the room for a new spectator
+func (r *Room) SpectatorSnapshot() SpectateJoinedMessage {
+	players := r.GetPlayersPublic()
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	chatHistory := make([]ChatRecord, 0, len(r.chatHistory))
+	for _, record := range r.chatHistory {
+		if record.Channel == "" {
+			chatHistory = append(chatHistory, record)
+		}
+	}
+
+	snapshot := SpectateJoinedMessage{
+		Type:                "spectate-joined",
+		RoomCode:            r.code,
+		Players:             players,
+		Settings:            r.settings,
+		GameState:           r.gameState,
+		TimeRemaining:       r.timeRemaining,
+		VotingTimeRemaining: r.votingTimeRemaining,
+		ChatMessages:        chatHistory,
+	}
+	if r.gameState != StateLobby {
+		editHistory := make([]EditRecord, len(r.editHistory))
+		copy(editHistory, r.editHistory)
+		code, revision := r.currentCode, r.revision
+		snapshot.Task = r.currentTask
+		snapshot.Language = r.currentTask.Language
+		snapshot.EditorMode = editorMode(r.currentTask.Language)
+		snapshot.Code = &code
+		snapshot.Revision = &revision
+		snapshot.EditHistory = editHistory
+	}
+	return snapshot
+}
+
+func (c *Client) handleSpectateRoom(roomCode, password string) {
+	if c.room != nil {
+		c.sendError(ErrCodeInvalidState, "Players can't spectate!")
+		return
+	}
+
+	room := c.hub.GetRoom(roomCode)
+	if room == nil {
+		c.sendError(ErrCodeNotFound, "Room not found!")
+		return
+	}
+
+	if !room.CheckPassword(password) {
+		c.sendError(ErrCodeWrongPassword, "Wrong password!")
+		return
+	}
+
+	if c.spectating != nil {
+		c.spectating.RemoveSpectator(c)
+	}
+	room.AddSpectator(c)
+
+	log.Printf("👀 [LGTM] Spectator %s watching room: %s", c.id, roomCode)
+}
@@ -1,19 +1,98 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding/json"
+	"fmt"
+	"io"
 	"log"
+	"math/rand"
 	"os"
+	"regexp"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 )
 
 type Task struct {
-	ID           int        `json:"id"`
-	Title        string     `json:"title"`
-	Description  string     `json:"description"`
-	FunctionName string     `json:"functionName"`
-	StarterCode  string     `json:"starterCode"`
-	TestCases    []TestCase `json:"testCases"`
+	ID               int      `json:"id"`
+	Title            string   `json:"title"`
+	Description      string   `json:"description"`
+	FunctionName     string   `json:"functionName"`
+	Difficulty       string   `json:"difficulty,omitempty"`
+	Tags             []string `json:"tags,omitempty"`
+	EstimatedMinutes int      `json:"estimatedMinutes,omitempty"`
+	// TimeLimit overrides the room's game time, in seconds
+	TimeLimit int `json:"timeLimit,omitempty"`
+	// Language is the language StarterCode is written in, javascript if
+	// empty. StarterCodes adds starters for other languages.
+	Language     string            `json:"language,omitempty"`
+	StarterCode  string            `json:"starterCode"`
+	StarterCodes map[string]string `json:"starterCodes,omitempty"`
+	TestCases    []TestCase        `json:"testCases"`
+}
+
+// TaskDifficulties are the allowed difficulty values, easiest first
+var TaskDifficulties = []string{"easy", "medium", "hard"}
+
+func isTaskDifficulty(difficulty string) bool {
+	for _, d := range TaskDifficulties {
+		if d == difficulty {
+			return true
+		}
+	}
+	return false
+}
+
+// HasTag reports whether the task is tagged with tag, ignoring case
+func (t *Task) HasTag(tag string) bool {
+	for _, own := range t.Tags {
+		if strings.EqualFold(own, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// PrimaryLanguage is the language of StarterCode
+func (t *Task) PrimaryLanguage() string {
+	if t.Language == "" {
+		return defaultLanguage
+	}
+	return t.Language
+}
+
+// SupportedLanguages lists every language the task has a starter for
+func (t *Task) SupportedLanguages() []string {
+	languages := []string{t.PrimaryLanguage()}
+	for _, name := range LanguageNames() {
+		if _, ok := t.StarterCodes[name]; ok && name != t.PrimaryLanguage() {
+			languages = append(languages, name)
+		}
+	}
+	return languages
+}
+
+// StarterFor returns the starter code for a language
+func (t *Task) StarterFor(language string) (string, bool) {
+	if language == t.PrimaryLanguage() {
+		return t.StarterCode, true
+	}
+	code, ok := t.StarterCodes[language]
+	return code, ok
+}
+
+// ForLanguage returns a copy of the task as played in language, with
+// StarterCode set to that language's starter
+func (t *Task) ForLanguage(language string) *Task {
+	starter, _ := t.StarterFor(language)
+	task := *t
+	task.Language = language
+	task.StarterCode = starter
+	task.StarterCodes = nil
+	return &task
 }
 
 type TestCase struct {
@@ -21,37 +100,308 @@ type TestCase struct {
 	Expected interface{} `json:"expected"`
 }
 
+const maxFilterTags = 10
+
 var (
 	Tasks      []Task
 	tasksMutex sync.RWMutex
 )
 
-// LoadTasks loads tasks from tasks.json file
-func LoadTasks() error {
-	tasksMutex.Lock()
-	defer tasksMutex.Unlock()
+var (
+	identifierPattern = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+	tagPattern        = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+)
+
+// TaskCatalogError lists every problem found in a task file
+type TaskCatalogError struct {
+	Problems []string
+}
+
+func (e *TaskCatalogError) Error() string {
+	return fmt.Sprintf("%d problem(s) in task catalog:\n  %s", len(e.Problems), strings.Join(e.Problems, "\n  "))
+}
 
-	// Load from file
-	data, err := os.ReadFile("tasks.json")
+// LoadTasks loads and validates tasks from path, replacing the catalog
+// only if the whole file is valid
+func LoadTasks(path string) error {
+	loadedTasks, err := ReadTasks(path)
 	if err != nil {
 		return err
 	}
 
-	// Parse JSON
+	tasksMutex.Lock()
+	Tasks = loadedTasks
+	tasksMutex.Unlock()
+
+	log.Printf("[LGTM] Loaded %d tasks from %s", len(loadedTasks), path)
+	return nil
+}
+
+// ReadTasks parses and validates a task file without touching the catalog
+func ReadTasks(path string) ([]Task, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Unknown fields are usually typos like "testCase" for "testCases"
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
 	var loadedTasks []Task
-	if err := json.Unmarshal(data, &loadedTasks); err != nil {
-		return err
+	if err := decoder.Decode(&loadedTasks); err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after task list")
 	}
 
-	if len(loadedTasks) == 0 {
-		log.Printf("[LGTM] Warning: tasks.json is empty")
+	if err := ValidateTasks(loadedTasks); err != nil {
+		return nil, err
 	}
+	return loadedTasks, nil
+}
 
-	Tasks = loadedTasks
-	log.Printf("[LGTM] Loaded %d tasks from tasks.json", len(Tasks))
+// ValidateTasks checks every task and reports all problems at once
+func ValidateTasks(tasks []Task) error {
+	problems := make([]string, 0)
+	if len(tasks) == 0 {
+		problems = append(problems, "catalog has no tasks")
+	}
+
+	seen := make(map[int]int) // id -> index
+	for i, task := range tasks {
+		label := fmt.Sprintf("task #%d (id %d, %q)", i+1, task.ID, task.Title)
+		report := func(format string, args ...interface{}) {
+			problems = append(problems, label+": "+fmt.Sprintf(format, args...))
+		}
+
+		if task.ID <= 0 {
+			report("id must be a positive number")
+		} else if first, ok := seen[task.ID]; ok {
+			report("duplicate id, already used by task #%d", first+1)
+		} else {
+			seen[task.ID] = i
+		}
+		if strings.TrimSpace(task.Title) == "" {
+			report("missing title")
+		}
+		validName := false
+		if task.FunctionName == "" {
+			report("missing functionName")
+		} else if !identifierPattern.MatchString(task.FunctionName) {
+			report("functionName %q is not a valid identifier", task.FunctionName)
+		} else {
+			validName = true
+		}
+		if task.Language != "" && Languages[task.Language] == nil {
+			report("unknown language %q (supported: %s)", task.Language, strings.Join(LanguageNames(), ", "))
+		}
+		if strings.TrimSpace(task.StarterCode) == "" {
+			report("missing starterCode")
+		} else if validName && !strings.Contains(task.StarterCode, task.FunctionName) {
+			report("starterCode does not define %s", task.FunctionName)
+		}
+		for language, starter := range task.StarterCodes {
+			if Languages[language] == nil {
+				report("starterCodes has unknown language %q", language)
+			} else if strings.TrimSpace(starter) == "" {
+				report("starterCodes[%q] is empty", language)
+			} else if validName && !strings.Contains(starter, task.FunctionName) {
+				report("starterCodes[%q] does not define %s", language, task.FunctionName)
+			}
+		}
+		if len(task.TestCases) == 0 {
+			report("testCases is empty")
+		}
+		if task.Difficulty != "" && !isTaskDifficulty(task.Difficulty) {
+			report("difficulty %q must be one of %s", task.Difficulty, strings.Join(TaskDifficulties, ", "))
+		}
+		tags := make(map[string]bool)
+		for _, tag := range task.Tags {
+			if !tagPattern.MatchString(tag) {
+				report("tag %q must be lowercase letters, digits and dashes", tag)
+			} else if tags[tag] {
+				report("duplicate tag %q", tag)
+			}
+			tags[tag] = true
+		}
+		if task.EstimatedMinutes < 0 {
+			report("estimatedMinutes can't be negative")
+		}
+		if task.TimeLimit != 0 && (task.TimeLimit < minGameTime || task.TimeLimit > maxGameTime) {
+			report("timeLimit must be between %d and %d seconds", minGameTime, maxGameTime)
+		}
+	}
+
+	if len(problems) > 0 {
+		return &TaskCatalogError{Problems: problems}
+	}
+	return nil
+}
+
+// TaskFilter narrows the tasks a room picks from. Empty lists match
+// every task; otherwise a task must match any one of the entries.
+type TaskFilter struct {
+	Difficulties []string `json:"difficulties"`
+	Tags         []string `json:"tags"`
+	// NoRepeats never picks a task the room has already played. Without
+	// it, played tasks come back once every matching task has been used.
+	NoRepeats bool `json:"noRepeats"`
+}
+
+func (f TaskFilter) Validate() error {
+	for _, d := range f.Difficulties {
+		if !isTaskDifficulty(d) {
+			return fmt.Errorf("difficulty must be one of %s", strings.Join(TaskDifficulties, ", "))
+		}
+	}
+	if len(f.Tags) > maxFilterTags {
+		return fmt.Errorf("at most %d tags can be selected", maxFilterTags)
+	}
 	return nil
 }
 
+// Matches reports whether a task passes the filter and can be played in
+// language ("" for any)
+func (f TaskFilter) Matches(task *Task, language string) bool {
+	if language != "" {
+		if _, ok := task.StarterFor(language); !ok {
+			return false
+		}
+	}
+	if len(f.Difficulties) > 0 {
+		found := false
+		for _, d := range f.Difficulties {
+			if task.Difficulty == d {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(f.Tags) > 0 {
+		found := false
+		for _, tag := range f.Tags {
+			if task.HasTag(tag) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// SelectTask picks a random matching task, preferring ones not in played
+func SelectTask(tasks []Task, filter TaskFilter, language string, played map[int]bool) (*Task, error) {
+	matching := make([]Task, 0)
+	fresh := make([]Task, 0)
+	for _, task := range tasks {
+		if !filter.Matches(&task, language) {
+			continue
+		}
+		matching = append(matching, task)
+		if !played[task.ID] {
+			fresh = append(fresh, task)
+		}
+	}
+
+	pool := fresh
+	if len(pool) == 0 && !filter.NoRepeats {
+		pool = matching
+	}
+	if len(pool) == 0 {
+		if len(matching) > 0 {
+			return nil, fmt.Errorf("every matching task has already been played")
+		}
+		return nil, fmt.Errorf("no tasks match the room's filters")
+	}
+
+	task := pool[rand.Intn(len(pool))]
+	return &task, nil
+}
+
+// TaskPool summarizes the catalog for a room's lobby
+type TaskPool struct {
+	Matching     int      `json:"matching"`
+	Unplayed     int      `json:"unplayed"`
+	Difficulties []string `json:"difficulties"`
+	Tags         []string `json:"tags"` // every tag in the catalog
+}
+
+func NewTaskPool(tasks []Task, filter TaskFilter, language string, played map[int]bool) TaskPool {
+	pool := TaskPool{Difficulties: TaskDifficulties, Tags: make([]string, 0)}
+	seen := make(map[string]bool)
+	for _, task := range tasks {
+		for _, tag := range task.Tags {
+			if !seen[tag] {
+				seen[tag] = true
+				pool.Tags = append(pool.Tags, tag)
+			}
+		}
+		if filter.Matches(&task, language) {
+			pool.Matching++
+			if !played[task.ID] {
+				pool.Unplayed++
+			}
+		}
+	}
+	sort.Strings(pool.Tags)
+	return pool
+}
+
+// WatchTasks polls the task file and reloads it when its contents change.
+// A failed reload keeps the last good catalog. Running games hold their
+// own copy of their task, so they are unaffected either way.
+func WatchTasks(path string, interval time.Duration, stop <-chan struct{}) {
+	var lastMod time.Time
+	var lastSum [32]byte
+	if info, err := os.Stat(path); err == nil {
+		lastMod = info.ModTime()
+	}
+	if data, err := os.ReadFile(path); err == nil {
+		lastSum = sha256.Sum256(data)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			// Cheap mtime check first, then compare contents so touching
+			// the file doesn't trigger a reload
+			info, err := os.Stat(path)
+			if err != nil || info.ModTime().Equal(lastMod) {
+				continue
+			}
+			lastMod = info.ModTime()
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				continue
+			}
+			sum := sha256.Sum256(data)
+			if sum == lastSum {
+				continue
+			}
+			lastSum = sum
+
+			if err := LoadTasks(path); err != nil {
+				log.Printf("[LGTM] Task reload failed, keeping previous catalog: %v", err)
+			}
+
+		case <-stop:
+			return
+		}
+	}
+}
+
 // GetTasks returns a copy of the current tasks (thread-safe)
 func GetTasks() []Task {
 	tasksMutex.RLock()
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func testTask(id int) Task {
+	return Task{
+		ID:           id,
+		Title:        "Sum",
+		FunctionName: "sum",
+		StarterCode:  "function sum(a, b) {\n}",
+		TestCases:    []TestCase{{Input: []interface{}{1, 2}, Expected: 3}},
+	}
+}
+
+func TestValidateTasks(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(tasks []Task) []Task
+		want   string // a reported problem contains this; "" for none
+	}{
+		{"valid", func(tasks []Task) []Task { return tasks }, ""},
+		{"empty catalog", func(tasks []Task) []Task { return nil }, "catalog has no tasks"},
+		{"zero id", func(tasks []Task) []Task { tasks[1].ID = 0; return tasks }, "id must be a positive number"},
+		{"duplicate id", func(tasks []Task) []Task { tasks[1].ID = 1; return tasks }, "duplicate id, already used by task #1"},
+		{"blank title", func(tasks []Task) []Task { tasks[0].Title = " "; return tasks }, "missing title"},
+		{"no function name", func(tasks []Task) []Task { tasks[0].FunctionName = ""; return tasks }, "missing functionName"},
+		{"bad function name", func(tasks []Task) []Task { tasks[0].FunctionName = "2sum"; return tasks }, "not a valid identifier"},
+		{"no starter", func(tasks []Task) []Task { tasks[0].StarterCode = ""; return tasks }, "missing starterCode"},
+		{"starter without the function", func(tasks []Task) []Task { tasks[0].StarterCode = "function add() {}"; return tasks }, "starterCode does not define sum"},
+		{"no test cases", func(tasks []Task) []Task { tasks[0].TestCases = nil; return tasks }, "testCases is empty"},
+		{"unknown difficulty", func(tasks []Task) []Task { tasks[0].Difficulty = "brutal"; return tasks }, `difficulty "brutal" must be one of`},
+		{"uppercase tag", func(tasks []Task) []Task { tasks[0].Tags = []string{"Math"}; return tasks }, `tag "Math" must be lowercase`},
+		{"duplicate tag", func(tasks []Task) []Task { tasks[0].Tags = []string{"math", "math"}; return tasks }, `duplicate tag "math"`},
+		{"negative estimate", func(tasks []Task) []Task { tasks[0].EstimatedMinutes = -1; return tasks }, "estimatedMinutes can't be negative"},
+		{"time limit too short", func(tasks []Task) []Task { tasks[0].TimeLimit = minGameTime - 1; return tasks }, "timeLimit must be between"},
+		{"time limit too long", func(tasks []Task) []Task { tasks[0].TimeLimit = maxGameTime + 1; return tasks }, "timeLimit must be between"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateTasks(tt.change([]Task{testTask(1), testTask(2)}))
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("got %v, want no error", err)
+				}
+				return
+			}
+			var catalogErr *TaskCatalogError
+			if !errors.As(err, &catalogErr) {
+				t.Fatalf("got %v, want a TaskCatalogError", err)
+			}
+			if !strings.Contains(strings.Join(catalogErr.Problems, "\n"), tt.want) {
+				t.Errorf("problems %q don't mention %q", catalogErr.Problems, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateTasksReportsEveryProblem(t *testing.T) {
+	bad := testTask(0)
+	bad.Title = ""
+	bad.TestCases = nil
+	err := ValidateTasks([]Task{testTask(1), bad})
+	var catalogErr *TaskCatalogError
+	if !errors.As(err, &catalogErr) || len(catalogErr.Problems) != 3 {
+		t.Fatalf("got %v, want 3 problems", err)
+	}
+	for _, problem := range catalogErr.Problems {
+		if !strings.HasPrefix(problem, "task #2 ") {
+			t.Errorf("problem %q isn't labelled with task #2", problem)
+		}
+	}
+}
+
+func TestTaskFilterMatches(t *testing.T) {
+	task := testTask(1)
+	task.Difficulty = "medium"
+	task.Tags = []string{"math", "arrays"}
+	task.StarterCodes = map[string]string{"python": "def sum(a, b):\n    pass"}
+
+	tests := []struct {
+		name     string
+		filter   TaskFilter
+		language string
+		want     bool
+	}{
+		{"empty filter", TaskFilter{}, "", true},
+		{"difficulty", TaskFilter{Difficulties: []string{"easy", "medium"}}, "", true},
+		{"other difficulty", TaskFilter{Difficulties: []string{"hard"}}, "", false},
+		{"any one tag", TaskFilter{Tags: []string{"strings", "arrays"}}, "", true},
+		{"tag ignores case", TaskFilter{Tags: []string{"MATH"}}, "", true},
+		{"no tag", TaskFilter{Tags: []string{"strings"}}, "", false},
+		{"difficulty and tag", TaskFilter{Difficulties: []string{"medium"}, Tags: []string{"math"}}, "", true},
+		{"tag but not difficulty", TaskFilter{Difficulties: []string{"easy"}, Tags: []string{"math"}}, "", false},
+		{"primary language", TaskFilter{}, "javascript", true},
+		{"extra starter", TaskFilter{}, "python", true},
+		{"no starter", TaskFilter{}, "go", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Matches(&task, tt.language); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectTask(t *testing.T) {
+	tasks := []Task{testTask(1), testTask(2), testTask(3)}
+	tasks[0].Difficulty = "easy"
+	tasks[1].Difficulty = "easy"
+	tasks[2].Difficulty = "hard"
+	easy := TaskFilter{Difficulties: []string{"easy"}}
+
+	tests := []struct {
+		name   string
+		filter TaskFilter
+		played map[int]bool
+		want   []int // ids that may be picked; nil for an error
+	}{
+		{"any", TaskFilter{}, nil, []int{1, 2, 3}},
+		{"filtered", easy, nil, []int{1, 2}},
+		{"skips played", easy, map[int]bool{1: true}, []int{2}},
+		{"repeats once all are played", easy, map[int]bool{1: true, 2: true}, []int{1, 2}},
+		{"no repeats", TaskFilter{Difficulties: []string{"easy"}, NoRepeats: true}, map[int]bool{1: true, 2: true}, nil},
+		{"nothing matches", TaskFilter{Difficulties: []string{"medium"}}, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The pick is random, so try enough times to see every option
+			seen := make(map[int]bool)
+			for i := 0; i < 50; i++ {
+				task, err := SelectTask(tasks, tt.filter, "", tt.played)
+				if tt.want == nil {
+					if err == nil {
+						t.Fatalf("picked task %d, want an error", task.ID)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				seen[task.ID] = true
+			}
+			if len(seen) != len(tt.want) {
+				t.Errorf("picked %v, want each of %v", seen, tt.want)
+			}
+			for _, id := range tt.want {
+				if !seen[id] {
+					t.Errorf("never picked task %d", id)
+				}
+			}
+		})
+	}
+}
+
+func TestNewTaskPool(t *testing.T) {
+	tasks := []Task{testTask(1), testTask(2), testTask(3)}
+	tasks[0].Tags = []string{"math"}
+	tasks[1].Tags = []string{"strings", "math"}
+	tasks[2].Tags = []string{"arrays"}
+
+	pool := NewTaskPool(tasks, TaskFilter{Tags: []string{"math"}}, "", map[int]bool{2: true})
+	if pool.Matching != 2 || pool.Unplayed != 1 {
+		t.Errorf("got %d matching, %d unplayed; want 2, 1", pool.Matching, pool.Unplayed)
+	}
+	if strings.Join(pool.Tags, ",") != "arrays,math,strings" {
+		t.Errorf("got tags %v, want every tag once, sorted", pool.Tags)
+	}
+}